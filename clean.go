@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// assetFiles returns the static template files recorded in the manifest.
+func (m *Manifest) assetFiles() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := []string{}
+	for file := range m.Assets {
+		files = append(files, file)
+	}
+	return files
+}
+
+// cleanOutput removes every file the gallery generated in the output directory,
+// as recorded in the manifest, followed by the manifest itself and the
+// directories left empty. Files not generated by the gallery are left alone.
+// In a dry run, the files are only listed.
+func cleanOutput() error {
+	manifest = loadManifest()
+	files := append(manifest.files(), manifest.assetFiles()...)
+	files = append(files, manifestFile)
+	sort.Strings(files)
+	slog.Debug("Cleaning output directory", "files", len(files), "dryRun", dryRun)
+
+	removed := 0
+	for i, file := range files {
+		if i > 0 && file == files[i-1] {
+			continue
+		}
+		path := filepath.Join(config.Output, filepath.FromSlash(file))
+		if dryRun {
+			slog.Info("Would remove generated file", "file", path)
+			continue
+		}
+		slog.Debug("Removing generated file", "file", path)
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		removed++
+		removeEmptyDirs(filepath.Dir(path))
+	}
+	if !dryRun {
+		slog.Info("Output directory cleaned", "output", config.Output, "removedFiles", removed)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// options holds the command-line flags. The config flags override the
+// corresponding settings of the config file when set.
+type options struct {
+	Config       string
+	Originals    string
+	Output       string
+	Template     string
+	LogFormat    string
+	DryRun       bool
+	Addr         string
+	Watch        bool
+	PollInterval time.Duration
+}
+
+// command is a gallery subcommand.
+type command struct {
+	Name        string
+	Description string
+	// Flags registers the flags specific to the command
+	Flags func(fs *flag.FlagSet, opts *options)
+	Run   func(ctx context.Context, opts *options) error
+}
+
+// commands lists the gallery subcommands. The first one is run when no command is given.
+var commands = []command{
+	{
+		Name:        "build",
+		Description: "Generate the gallery in the output directory",
+		Flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.DryRun, "dry-run", false, "list orphaned output files instead of removing them")
+		},
+		Run: runBuild,
+	},
+	{
+		Name:        "clean",
+		Description: "Remove the files generated in the output directory",
+		Flags: func(fs *flag.FlagSet, opts *options) {
+			fs.BoolVar(&opts.DryRun, "dry-run", false, "list the generated files instead of removing them")
+		},
+		Run: runClean,
+	},
+	{
+		Name:        "serve",
+		Description: "Build the gallery and serve it over HTTP, rebuilding on changes",
+		Flags: func(fs *flag.FlagSet, opts *options) {
+			fs.StringVar(&opts.Addr, "addr", "localhost:8080", "address to listen on")
+			fs.BoolVar(&opts.Watch, "watch", true, "rebuild on changes to the originals, config file and template, and reload open browsers")
+			fs.DurationVar(&opts.PollInterval, "poll-interval", time.Second, "how often to check for changes")
+		},
+		Run: runServe,
+	},
+	{
+		Name:        "init",
+		Description: "Create a config file and originals directory",
+		Run:         runInit,
+	},
+	{
+		Name:        "validate",
+		Description: "Check the config file, originals directory and templates",
+		Run:         runValidate,
+	},
+}
+
+// run runs the gallery command given by the command-line arguments, and
+// returns the exit code: 0 on success, 1 on failure and 2 on invalid usage.
+func run(args []string, stderr io.Writer) int {
+	// Without a command, build the gallery, like before there were commands
+	name := commands[0].Name
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+	if name == "help" {
+		usage(stderr)
+		return 0
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command: %s\n\n", name)
+		usage(stderr)
+		return 2
+	}
+
+	opts := &options{}
+	fs := flag.NewFlagSet("gallery "+cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.Config, "config", "config.yml", "path of the config file")
+	fs.StringVar(&opts.Originals, "originals", "", "originals directory, overriding the config file")
+	fs.StringVar(&opts.Output, "output", "", "output directory, overriding the config file")
+	fs.StringVar(&opts.Template, "template", "", "template, overriding the config file")
+	fs.StringVar(&opts.LogFormat, "log-format", "text", "log format: text or json")
+	if cmd.Flags != nil {
+		cmd.Flags(fs, opts)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: gallery %s [flags]\n\n%s.\n\nFlags:\n", cmd.Name, cmd.Description)
+		fs.PrintDefaults()
+	}
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "Unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return 2
+	}
+
+	err = setupLogger(opts.LogFormat)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	// Stop gracefully on Ctrl-C or SIGTERM, and immediately on a second signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopInterrupted := context.AfterFunc(ctx, func() {
+		stop()
+		slog.Warn("Interrupted, finishing the files being written (interrupt again to exit immediately)")
+	})
+	defer stopInterrupted()
+
+	slog.Debug("Running command", "command", cmd.Name, "options", opts)
+	err = cmd.Run(ctx, opts)
+	if err != nil {
+		slog.Error("Failed to "+cmd.Name, "error", err)
+		return 1
+	}
+	return 0
+}
+
+// findCommand returns the command with the given name.
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// usage prints the available commands.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: gallery [command] [flags]\n\nCommands:\n")
+	for i, cmd := range commands {
+		description := cmd.Description
+		if i == 0 {
+			description += " (default)"
+		}
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.Name, description)
+	}
+	fmt.Fprintf(w, "\nRun \"gallery <command> -h\" for the flags of a command.\n")
+}
+
+// setupLogger sets the default logger, with the given format, the log level
+// from the LOG_LEVEL environment variable, and source locations if ADD_SOURCE is true.
+func setupLogger(format string) error {
+	// Get the log level from the environment variable
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info" // Default to info if not set
+	}
+	// Map the log level string to slog.Level
+	var level slog.Level
+	switch strings.ToLower(logLevel) {
+	case "debug":
+		level = slog.LevelDebug
+	case "info":
+		level = slog.LevelInfo
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo // Default to info if the input is invalid
+	}
+
+	// Get the add source option from the environment variable
+	// This option determines whether to include the source file and line number in the logs
+	addSourceEnv := os.Getenv("ADD_SOURCE")
+	addSource := strings.ToLower(addSourceEnv) == "true"
+
+	handlerOptions := &slog.HandlerOptions{Level: level, AddSource: addSource}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, handlerOptions)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, handlerOptions)))
+	default:
+		return fmt.Errorf("invalid log format: %s, must be one of: text, json", format)
+	}
+	return nil
+}
+
+// loadConfig loads the config file, and applies the config flags on top of it.
+func loadConfig(opts *options) error {
+	err := LoadConfig(opts.Config)
+	if err != nil {
+		return fmt.Errorf("failed to load config %s: %w", opts.Config, err)
+	}
+	if opts.Originals != "" {
+		config.Originals = opts.Originals
+	}
+	if opts.Output != "" {
+		config.Output = opts.Output
+	}
+	if opts.Template != "" {
+		config.Template = opts.Template
+	}
+	return config.validate()
+}
+
+// runBuild builds the gallery.
+func runBuild(ctx context.Context, opts *options) error {
+	err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	dryRun = opts.DryRun
+	return process(ctx)
+}
+
+// runClean removes the generated files from the output directory.
+func runClean(ctx context.Context, opts *options) error {
+	err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	dryRun = opts.DryRun
+	return cleanOutput()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anthonynsimon/bild/imgio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_Usage(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	assert.Equal(t, 0, run([]string{"help"}, stderr))
+	assert.Contains(t, stderr.String(), "validate")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"publish"}, stderr))
+	assert.Contains(t, stderr.String(), "Unknown command: publish")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"build", "--unknown"}, stderr))
+	assert.Contains(t, stderr.String(), "-dry-run")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"validate", "--log-format", "xml"}, stderr))
+	assert.Contains(t, stderr.String(), "invalid log format: xml")
+}
+
+func TestLoadConfigWithOverrides(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.yml")
+	err := os.WriteFile(configFile, []byte("name: Flags\noriginals: photos\noutput: public\n"), 0644)
+	assert.NoError(t, err)
+
+	// Flags override the config file, unset flags keep its values
+	err = loadConfig(&options{Config: configFile, Output: "site", Template: "default-imgid"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Flags", config.Name)
+	assert.Equal(t, "photos", config.Originals)
+	assert.Equal(t, "site", config.Output)
+	assert.Equal(t, "default-imgid", config.Template)
+
+	// The overridden config is validated, with the paths cleaned
+	err = loadConfig(&options{Config: configFile, Originals: "./photos/", Output: "/srv/gallery/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "photos", config.Originals)
+	assert.Equal(t, "/srv/gallery", config.Output)
+	err = loadConfig(&options{Config: configFile, Output: "./photos"})
+	assert.ErrorContains(t, err, "cannot be the same")
+}
+
+func TestRun_InitValidateBuildClean(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	tempDir := t.TempDir()
+	configFile := filepath.Join(tempDir, "config.yml")
+	originals := filepath.Join(tempDir, "originals")
+	output := filepath.Join(tempDir, "output")
+	stderr := &bytes.Buffer{}
+
+	// init creates the config file and the originals directory, but never overwrites the config file
+	assert.Equal(t, 0, run([]string{"init", "--config", configFile, "--originals", originals, "--output", output}, stderr))
+	assert.DirExists(t, originals)
+	assert.Equal(t, 1, run([]string{"init", "--config", configFile}, stderr))
+
+	// validate accepts the generated config, and reports a missing template
+	assert.Equal(t, 0, run([]string{"validate", "--config", configFile}, stderr))
+	assert.Equal(t, 1, run([]string{"validate", "--config", configFile, "--template", "missing"}, stderr))
+
+	// build generates the gallery from the originals in the config file
+	err := imgio.Save(filepath.Join(originals, "image1.jpg"), image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, run([]string{"build", "--config", configFile}, stderr))
+	assert.FileExists(t, filepath.Join(output, "thumb_image1.jpg"))
+
+	// clean removes the generated files, and leaves other files alone
+	err = os.MkdirAll(filepath.Join(output, "extra"), 0755)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(output, "extra", "robots.txt"), []byte("User-agent: *\n"), 0644)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, run([]string{"clean", "--config", configFile, "--dry-run"}, stderr))
+	assert.FileExists(t, filepath.Join(output, "thumb_image1.jpg"))
+	assert.Equal(t, 0, run([]string{"clean", "--config", configFile}, stderr))
+	entries, err := os.ReadDir(output)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.FileExists(t, filepath.Join(output, "extra", "robots.txt"))
+}
+
+func TestRun_BuildWithTrailingSlashes(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	tempDir := t.TempDir()
+	originals := filepath.Join(tempDir, "originals")
+	output := filepath.Join(tempDir, "output")
+	err := os.MkdirAll(filepath.Join(originals, "album"), 0755)
+	assert.NoError(t, err)
+	for _, name := range []string{"image1.jpg", filepath.Join("album", "image2.jpg")} {
+		err = imgio.Save(filepath.Join(originals, name), image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+		assert.NoError(t, err)
+	}
+
+	// Directories given with a trailing slash are walked like any other
+	stderr := &bytes.Buffer{}
+	args := []string{"build", "--config", filepath.Join(tempDir, "missing.yml"), "--originals", originals + "/", "--output", output + "/"}
+	assert.Equal(t, 0, run(args, stderr), stderr.String())
+	assert.FileExists(t, filepath.Join(output, "thumb_image1.jpg"))
+	assert.FileExists(t, filepath.Join(output, "album", "thumb_image2.jpg"))
+	content, err := os.ReadFile(filepath.Join(output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "thumb_image1.jpg")
+	assert.Contains(t, string(content), `href="album/"`)
+}
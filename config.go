@@ -4,46 +4,112 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Name          string `yaml:"name" default:"Photo Gallery"`
-	Copyright     string `yaml:"copyright" default:""`
-	Originals     string `yaml:"originals" default:"originals"`
-	Output        string `yaml:"output" default:"output"`
-	Template      string `yaml:"template" default:"default"`
-	ThumbSize     int    `yaml:"thumbnail_size" default:"200"`
-	FullSize      int    `yaml:"full_size" default:"2000"`
-	CopyOriginals bool   `yaml:"copy_originals" default:"false"`
-	ImageOrder    string `yaml:"image_order" default:"new"`
-	JPEGQuality   int    `yaml:"jpeg_quality" default:"90"`
-	GalleryPath   string `yaml:"gallery_path" default:"/"`
-	GalleryURL    string `yaml:"gallery_url" default:""`
-	RSSFeed       bool   `yaml:"rss_feed" default:"false"`
+	Name            string      `yaml:"name" default:"Photo Gallery"`
+	Copyright       string      `yaml:"copyright" default:""`
+	Originals       string      `yaml:"originals" default:"originals"`
+	Output          string      `yaml:"output" default:"output"`
+	Template        string      `yaml:"template" default:"default"`
+	ThumbSize       int         `yaml:"thumbnail_size" default:"200"`
+	FullSize        int         `yaml:"full_size" default:"2000"`
+	CopyOriginals   bool        `yaml:"copy_originals" default:"false"`
+	ImageOrder      string      `yaml:"image_order" default:"new"`
+	JPEGQuality     int         `yaml:"jpeg_quality" default:"90"`
+	OutputFormat    string      `yaml:"output_format" default:"jpeg"`
+	OutputFormats   []string    `yaml:"output_formats" default:""`
+	Sizes           []ImageSize `yaml:"sizes" default:""`
+	GalleryPath     string      `yaml:"gallery_path" default:"/"`
+	GalleryURL      string      `yaml:"gallery_url" default:""`
+	RSSFeed         bool        `yaml:"rss_feed" default:"false"`
+	Feeds           []string    `yaml:"feeds" default:""`
+	FeedTitle       string      `yaml:"feed_title" default:""`
+	FeedDescription string      `yaml:"feed_description" default:""`
+	FeedLanguage    string      `yaml:"feed_language" default:"en-us"`
+	FeedMaxItems    int         `yaml:"feed_max_items" default:"100"`
+	FeedMaxAge      int         `yaml:"feed_max_age" default:"0"` // In days, 0 for no limit
+	FeedDigest      bool        `yaml:"feed_digest" default:"false"`
+	FeedDigestTitle string      `yaml:"feed_digest_title" default:"%d new images"`
+	FeedDate        string      `yaml:"feed_date" default:"published"`
+	FolderFeeds     string      `yaml:"folder_feeds" default:"off"`
+	PubDatesFile    string      `yaml:"pubdates_file" default:""`
+	ImagePages      bool        `yaml:"image_pages" default:"false"`
+	Sitemap         bool        `yaml:"sitemap" default:"false"`
+	RobotsTxt       bool        `yaml:"robots_txt" default:"false"`
+	Prune           bool        `yaml:"prune" default:"true"`
+	OnError         string      `yaml:"on_error" default:"skip"`
+	dir             string      // Directory of the config file, where the publish dates are kept by default
+}
+
+// ImageSize is a named image width, generated for responsive srcset attributes.
+// In the config file it is either just the width, or a mapping with a name and a width.
+type ImageSize struct {
+	Name  string `yaml:"name"`
+	Width int    `yaml:"width"`
+}
+
+// UnmarshalYAML accepts both a plain width and a name/width mapping.
+func (s *ImageSize) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&s.Width)
+	}
+	type plain ImageSize
+	return node.Decode((*plain)(s))
 }
 
 var config Config
 
+// validLanguage matches language tags like en, en-us or de-CH, as used in the feeds.
+var validLanguage = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// validSizeName matches the names allowed for image sizes, as they're used in file names.
+var validSizeName = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
 // LoadConfig loads the configuration from a file.
 func LoadConfig(filename string) error {
 	// Initialize config with default values
 	slog.Debug("Loading config file", "filename", filename)
 	config = Config{
-		Name:          "Photo Gallery",
-		Copyright:     "",
-		Originals:     "originals",
-		Output:        "output",
-		Template:      "default",
-		ThumbSize:     200,
-		FullSize:      2000,
-		CopyOriginals: false,
-		ImageOrder:    "new",
-		JPEGQuality:   90,
-		GalleryPath:   "/",
-		GalleryURL:    "",
-		RSSFeed:       false,
+		Name:            "Photo Gallery",
+		Copyright:       "",
+		Originals:       "originals",
+		Output:          "output",
+		Template:        "default",
+		ThumbSize:       200,
+		FullSize:        2000,
+		CopyOriginals:   false,
+		ImageOrder:      "new",
+		JPEGQuality:     90,
+		OutputFormat:    "jpeg",
+		OutputFormats:   []string{},
+		Sizes:           []ImageSize{},
+		GalleryPath:     "/",
+		GalleryURL:      "",
+		RSSFeed:         false,
+		Feeds:           []string{},
+		FeedTitle:       "",
+		FeedDescription: "",
+		FeedLanguage:    "en-us",
+		FeedMaxItems:    100,
+		FeedMaxAge:      0,
+		FeedDigest:      false,
+		FeedDigestTitle: "%d new images",
+		FeedDate:        "published",
+		FolderFeeds:     "off",
+		PubDatesFile:    "",
+		ImagePages:      false,
+		Sitemap:         false,
+		RobotsTxt:       false,
+		Prune:           true,
+		OnError:         "skip",
+		dir:             filepath.Dir(filename),
 	}
 
 	data, err := os.ReadFile(filename)
@@ -61,28 +127,135 @@ func LoadConfig(filename string) error {
 	if err != nil {
 		return err
 	}
+	slog.Debug("Config file parsed successfully", "config", config)
+
+	return config.validate()
+}
+
+// validate checks the configuration, naming unnamed image sizes after their
+// width, and cleaning the originals and output paths, as the paths walked in
+// the originals directory are compared against them.
+func (c *Config) validate() error {
+	c.Originals = filepath.Clean(c.Originals)
+	c.Output = filepath.Clean(c.Output)
+
+	// Validate that ImageOrder is one of the allowed values
+	if !validImageOrder(c.ImageOrder) {
+		return fmt.Errorf("invalid image order: %s, must be one of: %s", c.ImageOrder, strings.Join(imageOrders, ", "))
+	}
 
-	// Validate that ImageOrder is one of the allowed values ("new", "old", "alphabetical")
-	if config.ImageOrder != "new" && config.ImageOrder != "old" && config.ImageOrder != "alphabetical" {
-		return fmt.Errorf("invalid image order: %s, must be one of: new, old, alphabetical", config.ImageOrder)
+	// Validate that OnError is one of the allowed values ("skip", "fail")
+	if c.OnError != "skip" && c.OnError != "fail" {
+		return fmt.Errorf("invalid on_error: %s, must be one of: skip, fail", c.OnError)
 	}
 
-	// GalleryURL is required if RSSFeed is enabled
-	if config.RSSFeed && config.GalleryURL == "" {
-		return fmt.Errorf("gallery_url is required when rss_feed is enabled")
+	// Validate that OutputFormat is a format we can write
+	format, ok := formatByName(c.OutputFormat)
+	if !ok {
+		return fmt.Errorf("invalid output format: %s", c.OutputFormat)
+	}
+	if format.Encoder == nil {
+		return fmt.Errorf("output format %s is not supported for writing", c.OutputFormat)
+	}
+
+	// Validate that OutputFormats only lists formats we can write, and that are
+	// worth offering to browsers, as they prefer them over the output format
+	for _, name := range c.OutputFormats {
+		format, ok := formatByName(name)
+		if !ok {
+			return fmt.Errorf("invalid output format: %s", name)
+		}
+		if format.Encoder == nil {
+			return fmt.Errorf("output format %s is not supported for writing", name)
+		}
+		if !format.Variant {
+			return fmt.Errorf("invalid output_formats: %s, must be one of: %s", name, strings.Join(variantFormats(), ", "))
+		}
+	}
+
+	// Validate the image sizes, naming unnamed sizes after their width. The
+	// names of the other derived images are reserved, as sizes share their prefixes
+	sizeNames := map[string]bool{"thumb": true, "full": true, "cover": true}
+	for i := range c.Sizes {
+		size := &c.Sizes[i]
+		if size.Width <= 0 {
+			return fmt.Errorf("invalid image size width: %d", size.Width)
+		}
+		if size.Name == "" {
+			size.Name = "w" + strconv.Itoa(size.Width)
+		}
+		if !validSizeName.MatchString(size.Name) {
+			return fmt.Errorf("invalid image size name: %s, must only contain letters, digits and dashes", size.Name)
+		}
+		if sizeNames[size.Name] {
+			return fmt.Errorf("duplicate or reserved image size name: %s", size.Name)
+		}
+		sizeNames[size.Name] = true
+	}
+
+	// Validate the feed formats, rss_feed being a shorthand for listing rss
+	feedNames := map[string]bool{}
+	for _, name := range c.Feeds {
+		if _, ok := feedFormatByName(name); !ok {
+			return fmt.Errorf("invalid feed: %s, must be one of: rss, atom, json", name)
+		}
+		if feedNames[name] {
+			return fmt.Errorf("duplicate feed: %s", name)
+		}
+		feedNames[name] = true
+	}
+	// Validate that FeedDate is one of the allowed values ("published", "taken")
+	if c.FeedDate != "published" && c.FeedDate != "taken" {
+		return fmt.Errorf("invalid feed_date: %s, must be one of: published, taken", c.FeedDate)
+	}
+	// Validate the feed language, size and digest
+	if !validLanguage.MatchString(c.FeedLanguage) {
+		return fmt.Errorf("invalid feed_language: %s, must be a language tag like en-us", c.FeedLanguage)
+	}
+	if c.FeedMaxItems < 1 {
+		return fmt.Errorf("invalid feed_max_items: %d, must be at least 1", c.FeedMaxItems)
+	}
+	if c.FeedMaxAge < 0 {
+		return fmt.Errorf("invalid feed_max_age: %d, must be a number of days, or 0 for no limit", c.FeedMaxAge)
+	}
+	if strings.Count(c.FeedDigestTitle, "%") != 1 || !strings.Contains(c.FeedDigestTitle, "%d") {
+		return fmt.Errorf("invalid feed_digest_title: %s, must contain %%d once, for the number of images", c.FeedDigestTitle)
+	}
+	if c.FeedDigest && c.FeedDate == "taken" {
+		return fmt.Errorf("feed_digest can't be combined with feed_date: taken, as digests group images by when they were published")
+	}
+
+	// Validate that FolderFeeds is one of the allowed values
+	if !validFolderFeedMode(c.FolderFeeds) {
+		return fmt.Errorf("invalid folder_feeds: %s, must be one of: %s", c.FolderFeeds, strings.Join(folderFeedModes, ", "))
+	}
+	feedsEnabled := c.RSSFeed || len(c.Feeds) > 0
+
+	// GalleryURL is required if feeds are enabled
+	if feedsEnabled && c.GalleryURL == "" {
+		return fmt.Errorf("gallery_url is required when feeds are enabled")
+	}
+	// The sitemap lists absolute URLs, and robots.txt is only read at the root of the site
+	if c.Sitemap && c.GalleryURL == "" {
+		return fmt.Errorf("gallery_url is required when the sitemap is enabled")
+	}
+	if c.RobotsTxt && !c.Sitemap {
+		return fmt.Errorf("robots_txt requires the sitemap to be enabled")
+	}
+	if c.RobotsTxt && c.GalleryPath != "/" {
+		return fmt.Errorf("robots_txt requires gallery_path to be /, as robots.txt is only read at the root of the site")
 	}
 	// Check that the GalleryURL looks just somewhat like a URL
 	// This is a very basic check, we might want to use a more robust URL validation
 	isValidURL := func(url string) bool {
-		return len(url) > 0 && (url[:7] == "http://" || url[:8] == "https://")
+		return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
 	}
-	// Validate the GalleryURL if RSSFeed is enabled
-	if config.RSSFeed && !isValidURL(config.GalleryURL) {
-		return fmt.Errorf("invalid gallery_url: %s", config.GalleryURL)
+	// Validate the GalleryURL if feeds or the sitemap are enabled
+	if (feedsEnabled || c.Sitemap) && !isValidURL(c.GalleryURL) {
+		return fmt.Errorf("invalid gallery_url: %s", c.GalleryURL)
 	}
 
-	slog.Debug("Config file parsed successfully", "config", config)
-	if config.Originals == config.Output {
+	if c.Originals == c.Output {
 		return fmt.Errorf("the \"originals\" and \"output\" directories cannot be the same")
 	}
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,8 +38,18 @@ func TestLoadConfig_Defaults(t *testing.T) {
 	assert.Equal(t, false, config.CopyOriginals)
 	assert.Equal(t, "new", config.ImageOrder)
 	assert.Equal(t, 90, config.JPEGQuality)
+	assert.Equal(t, "jpeg", config.OutputFormat)
 	assert.Equal(t, "/", config.GalleryPath)
 	assert.Equal(t, false, config.RSSFeed)
+	assert.Equal(t, "published", config.FeedDate)
+	assert.Equal(t, "", config.PubDatesFile)
+	assert.Equal(t, "off", config.FolderFeeds)
+	assert.Equal(t, "en-us", config.FeedLanguage)
+	assert.Equal(t, 100, config.FeedMaxItems)
+	assert.Equal(t, 0, config.FeedMaxAge)
+	assert.Equal(t, false, config.FeedDigest)
+	assert.Equal(t, true, config.Prune)
+	assert.Equal(t, "skip", config.OnError)
 }
 
 func TestLoadConfig_ValidFile(t *testing.T) {
@@ -91,6 +102,7 @@ func TestLoadConfig_MissingFile(t *testing.T) {
 	assert.Equal(t, "Photo Gallery", config.Name)
 	assert.Equal(t, "originals", config.Originals)
 	assert.Equal(t, "output", config.Output)
+	assert.Equal(t, false, config.ImagePages)
 }
 
 func TestLoadConfig_InvalidYAML(t *testing.T) {
@@ -127,3 +139,137 @@ output: same_path
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "the \"originals\" and \"output\" directories cannot be the same")
 }
+
+func TestLoadConfig_InvalidOnError(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	tempFile, err := os.CreateTemp("", "config_invalid_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.Write([]byte("on_error: ignore\n"))
+	assert.NoError(t, err)
+	tempFile.Close()
+
+	err = LoadConfig(tempFile.Name())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid on_error: ignore")
+}
+
+func TestLoadConfig_InvalidOutputFormat(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	// Create temporary YAML configuration files with unknown output formats
+	for content, message := range map[string]string{
+		"output_format: heic\n":          "invalid output format: heic",
+		"output_formats: [webp, avif]\n": "invalid output format: avif",
+		"output_formats: [webp, png]\n":  "invalid output_formats: png, must be one of: webp",
+	} {
+		tempFile, err := os.CreateTemp("", "config_invalid_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(tempFile.Name())
+
+		_, err = tempFile.Write([]byte(content))
+		assert.NoError(t, err)
+		tempFile.Close()
+
+		// Call LoadConfig with the invalid file
+		err = LoadConfig(tempFile.Name())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), message)
+	}
+}
+
+func TestLoadConfig_Sizes(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	// Create a temporary YAML configuration file with plain and named sizes
+	tempFile, err := os.CreateTemp("", "config_*.yaml")
+	assert.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+
+	configContent := `
+sizes:
+  - 480
+  - name: large
+    width: 1600
+`
+	_, err = tempFile.Write([]byte(configContent))
+	assert.NoError(t, err)
+	tempFile.Close()
+
+	// Call LoadConfig with the temporary file
+	err = LoadConfig(tempFile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []ImageSize{{Name: "w480", Width: 480}, {Name: "large", Width: 1600}}, config.Sizes)
+}
+
+func TestLoadConfig_InvalidSizes(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	for content, message := range map[string]string{
+		"sizes: [0]\n":                           "invalid image size width: 0",
+		"sizes: [{name: thumb, width: 480}]\n":   "duplicate or reserved image size name: thumb",
+		"sizes: [{name: cover, width: 480}]\n":   "duplicate or reserved image size name: cover",
+		"sizes: [480, 480]\n":                    "duplicate or reserved image size name: w480",
+		"sizes: [{name: \"a b\", width: 480}]\n": "invalid image size name: a b",
+	} {
+		tempFile, err := os.CreateTemp("", "config_invalid_*.yaml")
+		assert.NoError(t, err)
+		defer os.Remove(tempFile.Name())
+
+		_, err = tempFile.Write([]byte(content))
+		assert.NoError(t, err)
+		tempFile.Close()
+
+		// Call LoadConfig with the invalid file
+		err = LoadConfig(tempFile.Name())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), message)
+	}
+}
+
+func TestLoadConfig_Feeds(t *testing.T) {
+	// Restore the default configuration for the following tests
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	tests := map[string]string{
+		"feeds: [atom, json]\n":                                 "gallery_url is required when feeds are enabled",
+		"feeds: [atom]\ngallery_url: example.com\n":             "invalid gallery_url: example.com",
+		"feeds: [rdf]\ngallery_url: https://example.com\n":      "invalid feed: rdf",
+		"feeds: [rss, rss]\ngallery_url: https://example.com\n": "duplicate feed: rss",
+		"feed_date: modified\n":                                 "invalid feed_date: modified",
+		"folder_feeds: all\n":                                   "invalid folder_feeds: all",
+		"feed_language: english\n":                              "invalid feed_language: english",
+		"feed_max_items: 0\n":                                   "invalid feed_max_items: 0",
+		"feed_max_age: -1\n":                                    "invalid feed_max_age: -1",
+		"feed_digest_title: New images\n":                       "invalid feed_digest_title: New images",
+		"feed_digest: true\nfeed_date: taken\n":                 "feed_digest can't be combined with feed_date: taken",
+		"sitemap: true\n":                                       "gallery_url is required when the sitemap is enabled",
+		"robots_txt: true\n":                                    "robots_txt requires the sitemap to be enabled",
+		"sitemap: true\nrobots_txt: true\ngallery_url: https://example.com\ngallery_path: /photos/\n": "robots_txt requires gallery_path to be /",
+	}
+	for content, want := range tests {
+		configFile := filepath.Join(t.TempDir(), "config.yml")
+		err := os.WriteFile(configFile, []byte(content), 0644)
+		assert.NoError(t, err)
+		err = LoadConfig(configFile)
+		assert.ErrorContains(t, err, want, content)
+	}
+
+	// rss_feed is a shorthand for listing rss
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(configFile, []byte("rss_feed: true\nfeeds: [json]\ngallery_url: https://example.com\n"), 0644)
+	assert.NoError(t, err)
+	err = LoadConfig(configFile)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, format := range enabledFeeds() {
+		names = append(names, format.Name)
+	}
+	assert.Equal(t, []string{"rss", "json"}, names)
+}
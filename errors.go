@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// fileError is an error processing a file or directory of the gallery.
+type fileError struct {
+	Path string
+	Err  error
+}
+
+func (e *fileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *fileError) Unwrap() error {
+	return e.Err
+}
+
+// buildError is returned by process when one or more files failed to process.
+type buildError struct {
+	Failures []*fileError
+	Stopped  bool // Whether the build stopped at the first failure
+}
+
+func (e *buildError) Error() string {
+	lines := []string{fmt.Sprintf("%d file(s) failed to process", len(e.Failures))}
+	if e.Stopped {
+		lines[0] += ", stopped at the first failure"
+	}
+	for _, failure := range e.Failures {
+		lines = append(lines, failure.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *buildError) Unwrap() []error {
+	errs := []error{}
+	for _, failure := range e.Failures {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// errorCollector receives the errors of the workers over a channel, and
+// collects them for the summary at the end of the build.
+type errorCollector struct {
+	errs     chan error
+	failed   chan struct{} // Closed on the first failure
+	done     chan struct{}
+	failures []*fileError
+}
+
+// newErrorCollector returns an error collector, receiving errors until it's closed.
+func newErrorCollector() *errorCollector {
+	c := &errorCollector{
+		errs:   make(chan error),
+		failed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.collect()
+	return c
+}
+
+// collect receives errors until the errors channel is closed.
+func (c *errorCollector) collect() {
+	defer close(c.done)
+	for err := range c.errs {
+		failure, ok := err.(*fileError)
+		if !ok {
+			failure = &fileError{Path: config.Output, Err: err}
+		}
+		slog.Error("Failed to process file", "path", failure.Path, "error", failure.Err)
+		c.failures = append(c.failures, failure)
+		if len(c.failures) == 1 {
+			close(c.failed)
+		}
+	}
+}
+
+// stopBuild reports whether the build should stop, because a file failed
+// and the on_error policy is to fail fast.
+func (c *errorCollector) stopBuild() bool {
+	if config.OnError != "fail" {
+		return false
+	}
+	select {
+	case <-c.failed:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops receiving errors, logs a summary of the failures, and returns
+// them as a build error, or nil if nothing failed.
+func (c *errorCollector) close() error {
+	close(c.errs)
+	<-c.done
+	if len(c.failures) == 0 {
+		return nil
+	}
+	slog.Error("Build finished with errors", "failures", len(c.failures), "onError", config.OnError)
+	for _, failure := range c.failures {
+		slog.Error("Failed", "path", failure.Path, "error", failure.Err)
+	}
+	return &buildError{Failures: c.failures, Stopped: config.OnError == "fail"}
+}
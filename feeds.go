@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// feedFormat describes a feed format the gallery can publish, rendered from
+// the template, or encoded directly if Template is empty.
+type feedFormat struct {
+	Name     string // As listed in the feeds setting
+	File     string // File name in the output directory
+	Template string
+	MIMEType string
+	Title    string // Title of the alternate link, after the gallery name
+}
+
+// feedFormats lists the feed formats, in the order they're linked from the pages.
+var feedFormats = []feedFormat{
+	{
+		Name:     "rss",
+		File:     "rss.xml",
+		Template: "rss.go.xml",
+		MIMEType: "application/rss+xml",
+		Title:    "RSS Feed",
+	},
+	{
+		Name:     "atom",
+		File:     "atom.xml",
+		Template: "atom.go.xml",
+		MIMEType: "application/atom+xml",
+		Title:    "Atom Feed",
+	},
+	{
+		Name:     "json",
+		File:     "feed.json",
+		MIMEType: "application/feed+json",
+		Title:    "JSON Feed",
+	},
+}
+
+// feedFormatByName returns the feed format with the given name.
+func feedFormatByName(name string) (feedFormat, bool) {
+	for _, format := range feedFormats {
+		if format.Name == name {
+			return format, true
+		}
+	}
+	return feedFormat{}, false
+}
+
+// enabledFeeds returns the feed formats to publish: those listed in the feeds
+// setting, and RSS if rss_feed is enabled.
+func enabledFeeds() []feedFormat {
+	formats := []feedFormat{}
+	for _, format := range feedFormats {
+		if slices.Contains(config.Feeds, format.Name) || (format.Name == "rss" && config.RSSFeed) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// folderFeedModes lists the values of the folder_feeds setting, and of the
+// feed setting of folders: no feed of their own, a feed of the images of the
+// folder, or of the folder and its subfolders.
+var folderFeedModes = []string{"off", "folder", "subtree"}
+
+// validFolderFeedMode reports whether a folder feed mode is one of the allowed values.
+func validFolderFeedMode(mode string) bool {
+	return slices.Contains(folderFeedModes, mode)
+}
+
+// folderFeed is a feed of the images of a folder, written in its output
+// directory. The site-wide feed is the one with an empty Dir.
+type folderFeed struct {
+	Dir   string // Relative to the originals directory, with forward slashes
+	Title string
+}
+
+// folderFeed returns the feed of a directory, if it has one of its own.
+// The originals directory has the site-wide feed instead.
+func (d Dir) folderFeed() (folderFeed, bool) {
+	if d.Path == config.Originals || d.Folder.Feed == "" || d.Folder.Feed == "off" {
+		return folderFeed{}, false
+	}
+	title := d.Folder.Title
+	if title == "" {
+		title = d.Name
+	}
+	return folderFeed{Dir: manifestKey(d.Path), Title: title}, true
+}
+
+// feeds returns the feeds the images of a directory are part of: the site-wide
+// feed unless the directory is hidden, the feed of the directory itself, and
+// the feeds of its ancestors covering their subfolders. Hidden subfolders are
+// left out of the feeds of the folders they're hidden from.
+func (dm DirMap) feeds(dir string) []folderFeed {
+	feeds := []folderFeed{}
+	if !dm[dir].Folder.Hidden {
+		feeds = append(feeds, folderFeed{})
+	}
+	for current := dir; current != config.Originals; current = filepath.Dir(current) {
+		d, ok := dm[current]
+		if !ok {
+			break
+		}
+		if feed, ok := d.folderFeed(); ok && (current == dir || d.Folder.Feed == "subtree") {
+			feeds = append(feeds, feed)
+		}
+		if d.Folder.Hidden && !dm[filepath.Dir(current)].Folder.Hidden {
+			break
+		}
+	}
+	return feeds
+}
+
+// FeedLink represents a feed, for the alternate links of the pages, with
+// its MIME type, title and URL relative to the site root.
+type FeedLink struct {
+	Type  string
+	Title string
+	URL   string
+}
+
+// feedLinks returns the links to the enabled feeds of a directory, if it
+// has a feed of its own, followed by those of the site-wide feed.
+func feedLinks(dir Dir) []FeedLink {
+	links := []FeedLink{}
+	if feed, ok := dir.folderFeed(); ok {
+		for _, format := range enabledFeeds() {
+			links = append(links, FeedLink{
+				Type:  format.MIMEType,
+				Title: feed.Title + " " + format.Title,
+				URL:   path.Join("/", config.GalleryPath, feed.Dir, format.File),
+			})
+		}
+	}
+	for _, format := range enabledFeeds() {
+		links = append(links, FeedLink{
+			Type:  format.MIMEType,
+			Title: config.Name + " " + format.Title,
+			URL:   path.Join("/", config.GalleryPath, format.File),
+		})
+	}
+	return links
+}
+
+// jsonFeed is a JSON Feed 1.1 document, see https://www.jsonfeed.org/version/1.1/.
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Language    string         `json:"language,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+// jsonFeedItem is an item of a JSON Feed.
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url,omitempty"`
+	Title         string `json:"title,omitempty"`
+	ContentHTML   string `json:"content_html"`
+	Image         string `json:"image,omitempty"`
+	DatePublished string `json:"date_published,omitempty"`
+}
+
+// writeJSONFeed writes a feed as a JSON Feed.
+func writeJSONFeed(w io.Writer, feed RSSFeed) error {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		HomePageURL: feed.Link,
+		FeedURL:     feed.AtomLink,
+		Description: feed.Description,
+		Language:    feed.Language,
+		Items:       []jsonFeedItem{},
+	}
+	for _, item := range feed.Items {
+		jsonItem := jsonFeedItem{
+			ID:          item.GUID,
+			URL:         item.Link,
+			Title:       item.Title,
+			ContentHTML: item.Description,
+			Image:       item.ImageURL,
+		}
+		if !item.Date.IsZero() {
+			jsonItem.DatePublished = item.Date.Format(time.RFC3339)
+		}
+		doc.Items = append(doc.Items, jsonItem)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedLinks(t *testing.T) {
+	config.Name = "Test Gallery"
+	config.GalleryPath = "/gallery/"
+	config.RSSFeed = false
+	config.Feeds = []string{"json", "atom"}
+	t.Cleanup(func() { config.Feeds = []string{} })
+
+	// The links follow the order of the feed formats, not of the setting
+	assert.Equal(t, []FeedLink{
+		{Type: "application/atom+xml", Title: "Test Gallery Atom Feed", URL: "/gallery/atom.xml"},
+		{Type: "application/feed+json", Title: "Test Gallery JSON Feed", URL: "/gallery/feed.json"},
+	}, feedLinks(Dir{}))
+
+	// A folder with a feed of its own links to it first
+	config.FolderFeeds = "folder"
+	t.Cleanup(func() { config.FolderFeeds = "off" })
+	dir := Dir{Name: "kids", Path: "originals/kids", Folder: FolderConfig{Title: "Kids", Feed: "folder"}}
+	config.Originals = "originals"
+	assert.Equal(t, []FeedLink{
+		{Type: "application/atom+xml", Title: "Kids Atom Feed", URL: "/gallery/kids/atom.xml"},
+		{Type: "application/feed+json", Title: "Kids JSON Feed", URL: "/gallery/kids/feed.json"},
+		{Type: "application/atom+xml", Title: "Test Gallery Atom Feed", URL: "/gallery/atom.xml"},
+		{Type: "application/feed+json", Title: "Test Gallery JSON Feed", URL: "/gallery/feed.json"},
+	}, feedLinks(dir))
+}
+
+func TestDirMapFeeds(t *testing.T) {
+	config.Originals = "originals"
+	dirs := DirMap{}
+	add := func(path string, folder FolderConfig) {
+		dirs.AddDir(path, filepath.Base(path), false)
+		dir := dirs[path]
+		dir.Folder = folder
+		dirs[path] = dir
+	}
+	add("originals", FolderConfig{Feed: "subtree"})
+	add("originals/kids", FolderConfig{Title: "Kids", Feed: "subtree"})
+	add("originals/kids/2026", FolderConfig{Feed: "folder"})
+	add("originals/kids/2026/private", FolderConfig{Hidden: true, Feed: "folder"})
+	add("originals/kids/2026/private/more", FolderConfig{Hidden: true, Feed: "off"})
+	add("originals/trips", FolderConfig{Feed: "off"})
+
+	site := folderFeed{}
+	kids := folderFeed{Dir: "kids", Title: "Kids"}
+	kids2026 := folderFeed{Dir: "kids/2026", Title: "2026"}
+	private := folderFeed{Dir: "kids/2026/private", Title: "private"}
+
+	// The originals directory only has the site-wide feed
+	assert.Equal(t, []folderFeed{site}, dirs.feeds("originals"))
+	assert.Equal(t, []folderFeed{site}, dirs.feeds("originals/trips"))
+	assert.Equal(t, []folderFeed{site, kids}, dirs.feeds("originals/kids"))
+	// Only subtree feeds of the ancestors include the images of subfolders
+	assert.Equal(t, []folderFeed{site, kids2026, kids}, dirs.feeds("originals/kids/2026"))
+	// Hidden folders are left out of the feeds they're hidden from
+	assert.Equal(t, []folderFeed{private}, dirs.feeds("originals/kids/2026/private"))
+	assert.Empty(t, dirs.feeds("originals/kids/2026/private/more"))
+}
+
+func TestWriteJSONFeed(t *testing.T) {
+	date := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	feed := RSSFeed{
+		Title:    "Test Gallery",
+		Link:     "https://example.com/",
+		AtomLink: "https://example.com/feed.json",
+		Items: []RSSItem{{
+			Title:       `Sunset "at" sea`,
+			Description: `<img src="thumb_sunset.jpg" />`,
+			ImageURL:    "https://example.com/full_sunset.jpg",
+			Link:        "https://example.com/#sunset.jpg",
+			Date:        date,
+			GUID:        "https://example.com/#sunset.jpg",
+		}},
+	}
+
+	var buf bytes.Buffer
+	err := writeJSONFeed(&buf, feed)
+	assert.NoError(t, err)
+
+	var doc jsonFeed
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://jsonfeed.org/version/1.1", doc.Version)
+	assert.Equal(t, "https://example.com/feed.json", doc.FeedURL)
+	assert.Equal(t, []jsonFeedItem{{
+		ID:            "https://example.com/#sunset.jpg",
+		URL:           "https://example.com/#sunset.jpg",
+		Title:         `Sunset "at" sea`,
+		ContentHTML:   `<img src="thumb_sunset.jpg" />`,
+		Image:         "https://example.com/full_sunset.jpg",
+		DatePublished: "2025-06-01T12:00:00Z",
+	}}, doc.Items)
+}
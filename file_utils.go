@@ -1,10 +1,13 @@
 package main
 
 import (
+	"image"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+
+	"github.com/anthonynsimon/bild/imgio"
 )
 
 // checkOrCreateOutputDir checks if the output directory exists, and creates it if it doesn't.
@@ -35,44 +38,112 @@ func copyFile(source, destination string) error {
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(destination)
+	err = writeFileAtomic(destination, func(w io.Writer) error {
+		_, err := io.Copy(w, sourceFile)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	slog.Debug("File copied", "source", source, "destination", destination)
+	return nil
+}
+
+// tempFilePrefix is the prefix of the temporary files written by writeFileAtomic.
+const tempFilePrefix = ".gallery-tmp-"
+
+// writeFileAtomic writes a file through a temporary file in the same directory,
+// renamed into place once completely written, so an interrupted or failed
+// build never leaves a truncated file behind.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), tempFilePrefix+filepath.Base(path)+"-*")
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
+	tempFile := f.Name()
+	slog.Debug("Writing temporary file", "path", path, "tempFile", tempFile)
 
-	_, err = io.Copy(destFile, sourceFile)
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// CreateTemp creates the file readable by the owner only
+		err = os.Chmod(tempFile, 0644)
+	}
 	if err == nil {
+		err = os.Rename(tempFile, path)
+	}
+	if err != nil {
+		os.Remove(tempFile)
 		return err
 	}
-	slog.Debug("File copied", "source", source, "destination", destination)
 	return nil
 }
 
-// updateTemplateFiles checks if the default.css and default.js files need to be
-// updated in the output dir, and updates them if necessary.
+// saveImage encodes an image to a file atomically.
+func saveImage(path string, img image.Image, encoder imgio.Encoder) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return encoder(w, img)
+	})
+}
+
+// updateTemplateFiles checks if the default.css, default.js and folder.svg files
+// need to be updated in the output dir, and updates them if necessary.
+// A file is updated when it's missing, or when the template file has changed
+// since it was last copied.
 func updateTemplateFiles() error {
 	slog.Debug("Updating template files")
 	templateFiles := []string{"default.css", "default.js", "folder.svg"}
 	for _, file := range templateFiles {
 		slog.Debug("Processing template file", "file", file)
 		outputFile := filepath.Join(config.Output, file)
-		inputFile := filepath.Join("templates", config.Template, file)
-		_, err := os.Stat(outputFile)
+		data, err := readTemplateFile(file)
 		if err != nil {
-			if os.IsNotExist(err) {
-				slog.Debug("Output file does not exist", "outputFile", outputFile)
-				// File doesn't exist, so we need to copy it
-				err := copyFile(inputFile, outputFile)
-				if err != nil {
-					return err
-				}
-				slog.Debug("Template file copied", "inputFile", inputFile, "outputFile", outputFile)
-			} else {
-				return err
-			}
+			return err
+		}
+		hash := hashData(data)
+		_, err = os.Stat(outputFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if os.IsNotExist(err) {
+			slog.Debug("Output file does not exist", "outputFile", outputFile)
+		} else if manifest.asset(file) != hash {
+			slog.Debug("Template file has changed", "file", file)
+		} else {
+			slog.Debug("Template file is up to date", "outputFile", outputFile)
+			continue
 		}
+		// File doesn't exist or is outdated, so we need to copy it
+		err = writeFileAtomic(outputFile, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		manifest.setAsset(file, hash)
+		slog.Debug("Template file copied", "file", file, "outputFile", outputFile)
 	}
 
 	return nil
 }
+
+// imageFileInfo returns the size in bytes and the dimensions of an image file.
+func imageFileInfo(path string) (int64, int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	imgConfig, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return info.Size(), imgConfig.Width, imgConfig.Height, nil
+}
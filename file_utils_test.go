@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -54,6 +56,39 @@ func TestCopyFile(t *testing.T) {
 	assert.Equal(t, content, destContent)
 }
 
+func TestWriteFileAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "file.txt")
+
+	// A completed write replaces the file, readable by everyone
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write([]byte("first"))
+		return err
+	})
+	assert.NoError(t, err)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(content))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// A failed write leaves the existing file untouched
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		w.Write([]byte("trunc"))
+		return errors.New("write failed")
+	})
+	assert.EqualError(t, err, "write failed")
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(content))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
 func TestUpdateTemplateFiles(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := t.TempDir()
@@ -105,3 +140,36 @@ func isDir(path string) bool {
 	}
 	return info.IsDir()
 }
+
+func TestUpdateTemplateFiles_Changed(t *testing.T) {
+	// Create a temporary directory for testing
+	tempDir := t.TempDir()
+	config.Output = filepath.Join(tempDir, "output")
+	config.Template = "default"
+	manifest = newManifest()
+
+	// Create the output directory and copy the template files
+	err := os.MkdirAll(config.Output, 0755)
+	assert.NoError(t, err)
+	err = updateTemplateFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, templateFileHash("default.css"), manifest.asset("default.css"))
+
+	// An unchanged template file isn't copied again
+	outputFile := filepath.Join(config.Output, "default.css")
+	err = os.WriteFile(outputFile, []byte("marker"), 0644)
+	assert.NoError(t, err)
+	err = updateTemplateFiles()
+	assert.NoError(t, err)
+	content, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "marker", string(content))
+
+	// A changed template file is copied again
+	manifest.setAsset("default.css", "outdated")
+	err = updateTemplateFiles()
+	assert.NoError(t, err)
+	content, err = os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), ":root {")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"gopkg.in/yaml.v3"
+)
+
+// folderConfigFiles are the names of the per-folder config files, in order of precedence.
+var folderConfigFiles = []string{"folder.yml", "_index.yml"}
+
+// FolderConfig is the configuration of an originals folder, from the optional
+// folder.yml (or _index.yml) in it. Hidden, ThumbSize, ImageOrder and Feed
+// are inherited by subfolders, defaulting to the global config, the other
+// settings only apply to the folder itself.
+type FolderConfig struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"` // Markdown
+	Sort        int    `yaml:"sort"`        // Position among the sibling folders, lowest first
+	Cover       string `yaml:"cover"`       // File name of the image representing the folder
+	Hidden      bool   `yaml:"hidden"`      // Left out of the parent's folders and feeds, but still generated
+	ThumbSize   int    `yaml:"thumbnail_size"`
+	ImageOrder  string `yaml:"image_order"`
+	Feed        string `yaml:"feed"` // Whether the folder has a feed of its own, see folderFeedModes
+	// Order lists the images and subfolders in manual order, read from the order file
+	Order []string `yaml:"-"`
+}
+
+// rootFolderConfig returns the configuration the originals directory inherits from the global config.
+func rootFolderConfig() FolderConfig {
+	return FolderConfig{
+		ThumbSize:  config.ThumbSize,
+		ImageOrder: config.ImageOrder,
+		Feed:       config.FolderFeeds,
+	}
+}
+
+// inherit returns the settings of a folder config inherited by its subfolders.
+func (f FolderConfig) inherit() FolderConfig {
+	return FolderConfig{
+		Hidden:     f.Hidden,
+		ThumbSize:  f.ThumbSize,
+		ImageOrder: f.ImageOrder,
+		Feed:       f.Feed,
+	}
+}
+
+// readFolderConfig reads the config file and order file of a folder, merged
+// on top of the settings inherited from its parent. Without a config file,
+// the folder just inherits its parent's settings. A file that fails to parse
+// is an error, with the inherited settings returned.
+func readFolderConfig(dir string, parent FolderConfig) (FolderConfig, error) {
+	folder, err := readFolderConfigFile(dir, parent.inherit())
+	if err != nil {
+		return folder, err
+	}
+	order, err := readOrderFile(dir)
+	if err != nil {
+		return folder, fmt.Errorf("failed to read %s: %w", orderFile, err)
+	}
+	folder.Order = order
+	return folder, nil
+}
+
+// readFolderConfigFile reads the first config file found in a folder, merged
+// on top of the given settings.
+func readFolderConfigFile(dir string, folder FolderConfig) (FolderConfig, error) {
+	for _, name := range folderConfigFiles {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return folder, err
+		}
+		slog.Debug("Reading folder config", "path", path)
+
+		merged := folder
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&merged)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return folder, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		err = merged.validate()
+		if err != nil {
+			return folder, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return merged, nil
+	}
+	return folder, nil
+}
+
+// validate checks the settings of a folder config.
+func (f FolderConfig) validate() error {
+	if f.ThumbSize <= 0 {
+		return fmt.Errorf("invalid thumbnail_size: %d", f.ThumbSize)
+	}
+	if !validImageOrder(f.ImageOrder) {
+		return fmt.Errorf("invalid image_order: %s, must be one of: %s", f.ImageOrder, strings.Join(imageOrders, ", "))
+	}
+	if f.Feed != "" && !validFolderFeedMode(f.Feed) {
+		return fmt.Errorf("invalid feed: %s, must be one of: %s", f.Feed, strings.Join(folderFeedModes, ", "))
+	}
+	if f.Cover != "" && filepath.Base(f.Cover) != f.Cover {
+		return fmt.Errorf("invalid cover: %s, must be the name of an image in the folder", f.Cover)
+	}
+	return nil
+}
+
+// isFolderConfigFile reports whether a file name is one of the folder config files, or the order file.
+func isFolderConfigFile(name string) bool {
+	if name == orderFile {
+		return true
+	}
+	for _, file := range folderConfigFiles {
+		if name == file {
+			return true
+		}
+	}
+	return false
+}
+
+// renderMarkdown renders markdown to HTML. Raw HTML in the markdown is left out.
+func renderMarkdown(source string) (string, error) {
+	var buf bytes.Buffer
+	err := goldmark.Convert([]byte(source), &buf)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// folderStats summarises the images of a directory and its subdirectories,
+// hidden ones excepted: how many there are, and the date and path of the newest.
+type folderStats struct {
+	Images int
+	Latest time.Time
+	Newest string
+}
+
+// add counts images, with the date and path of the newest of them.
+func (s *folderStats) add(images int, latest time.Time, newest string) {
+	s.Images += images
+	// Ties are broken by path, so the newest image doesn't depend on the walk order
+	if s.Newest == "" || latest.After(s.Latest) || (latest.Equal(s.Latest) && newest < s.Newest) {
+		s.Latest = latest
+		s.Newest = newest
+	}
+}
+
+// folderStats returns the stats of every directory, keyed by path.
+func (dm DirMap) folderStats() map[string]folderStats {
+	stats := map[string]folderStats{}
+	var collect func(path string) folderStats
+	collect = func(path string) folderStats {
+		if s, ok := stats[path]; ok {
+			return s
+		}
+		s := folderStats{}
+		dir := dm[path]
+		for filePath, file := range dir.Files {
+			s.add(1, file.date(), filePath)
+		}
+		for subPath, subDir := range dir.SubDirs {
+			if subDir.Hidden {
+				continue
+			}
+			if sub := collect(subPath); sub.Images > 0 {
+				s.add(sub.Images, sub.Latest, sub.Newest)
+			}
+		}
+		stats[path] = s
+		return s
+	}
+	for path := range dm {
+		collect(path)
+	}
+	return stats
+}
+
+// coverSource returns the path of the original the cover of a directory is
+// derived from: the cover set in its folder config, or else its newest image,
+// including those of its subdirectories. It's empty if there are no images.
+func coverSource(dir Dir, stats folderStats) string {
+	if dir.Folder.Cover != "" {
+		path := filepath.Join(dir.Path, dir.Folder.Cover)
+		if _, ok := dir.Files[path]; ok {
+			return path
+		}
+		slog.Warn("Folder cover is not an image of the folder, using the newest image", "path", dir.Path, "cover", dir.Folder.Cover)
+	}
+	return stats.Newest
+}
+
+// coverName returns the file name of the cover thumbnail derived from an original.
+func coverName(source string) string {
+	return derivedNameFor("cover", filepath.Base(source), outputFormat())
+}
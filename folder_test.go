@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFolderConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	parent := FolderConfig{Title: "Parent", Sort: 2, Hidden: true, ThumbSize: 200, ImageOrder: "new"}
+
+	// Without a config file, only the inherited settings apply
+	folder, err := readFolderConfig(tempDir, parent)
+	assert.NoError(t, err)
+	assert.Equal(t, FolderConfig{Hidden: true, ThumbSize: 200, ImageOrder: "new"}, folder)
+
+	// The config file is merged on top of the inherited settings
+	err = os.WriteFile(filepath.Join(tempDir, "_index.yml"), []byte("title: Holidays\nsort: 1\nimage_order: old\n"), 0644)
+	assert.NoError(t, err)
+	folder, err = readFolderConfig(tempDir, parent)
+	assert.NoError(t, err)
+	assert.Equal(t, FolderConfig{Title: "Holidays", Sort: 1, Hidden: true, ThumbSize: 200, ImageOrder: "old"}, folder)
+
+	// folder.yml takes precedence over _index.yml, and an empty file is fine
+	err = os.WriteFile(filepath.Join(tempDir, "folder.yml"), []byte(""), 0644)
+	assert.NoError(t, err)
+	folder, err = readFolderConfig(tempDir, parent)
+	assert.NoError(t, err)
+	assert.Equal(t, "", folder.Title)
+}
+
+func TestReadFolderConfig_Invalid(t *testing.T) {
+	parent := rootFolderConfig()
+	tests := map[string]string{
+		"unknown key":    "titel: Holidays\n",
+		"invalid yaml":   "title: [Holidays\n",
+		"thumbnail size": "thumbnail_size: 0\n",
+		"image order":    "image_order: random\n",
+		"cover path":     "cover: ../image1.jpg\n",
+		"feed":           "feed: yes\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			err := os.WriteFile(filepath.Join(tempDir, "folder.yml"), []byte(content), 0644)
+			assert.NoError(t, err)
+
+			// The inherited settings are returned along with the error
+			folder, err := readFolderConfig(tempDir, parent)
+			assert.ErrorContains(t, err, "folder.yml")
+			assert.Equal(t, parent.inherit(), folder)
+		})
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	html, err := renderMarkdown("Photos from **Rome**.\n\n<script>alert(1)</script>\n")
+	assert.NoError(t, err)
+	assert.Contains(t, html, "<p>Photos from <strong>Rome</strong>.</p>")
+	assert.NotContains(t, html, "<script>")
+
+	html, err = renderMarkdown("")
+	assert.NoError(t, err)
+	assert.Empty(t, html)
+}
+
+func TestFolderStats(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	dirs := DirMap{}
+	dirs.AddDir("/originals", "originals", false)
+	dirs.AddDir("/originals/trip", "trip", false)
+	dirs.AddDir("/originals/trip/day1", "day1", false)
+	dirs.AddDir("/originals/private", "private", false)
+	dirs["/originals"].SubDirs["/originals/trip"] = SubDir{Name: "trip"}
+	dirs["/originals"].SubDirs["/originals/private"] = SubDir{Name: "private", Hidden: true}
+	dirs["/originals/trip"].SubDirs["/originals/trip/day1"] = SubDir{Name: "day1"}
+	dirs["/originals/trip"].Files["/originals/trip/a.jpg"] = File{Name: "a.jpg", ModTime: older}
+	// The date the image was taken is preferred over its modification time
+	dirs["/originals/trip/day1"].Files["/originals/trip/day1/b.jpg"] = File{Name: "b.jpg", ModTime: older, Metadata: Metadata{DateTime: newer}}
+	dirs["/originals/private"].Files["/originals/private/c.jpg"] = File{Name: "c.jpg", ModTime: newer.Add(time.Hour)}
+
+	stats := dirs.folderStats()
+	assert.Equal(t, folderStats{Images: 2, Latest: newer, Newest: "/originals/trip/day1/b.jpg"}, stats["/originals/trip"])
+	// Hidden subdirectories aren't counted
+	assert.Equal(t, stats["/originals/trip"], stats["/originals"])
+	assert.Equal(t, 1, stats["/originals/private"].Images)
+
+	// The cover set in the folder config is preferred over the newest image
+	trip := dirs["/originals/trip"]
+	assert.Equal(t, "/originals/trip/day1/b.jpg", coverSource(trip, stats["/originals/trip"]))
+	trip.Folder.Cover = "a.jpg"
+	assert.Equal(t, "/originals/trip/a.jpg", coverSource(trip, stats["/originals/trip"]))
+	trip.Folder.Cover = "missing.jpg"
+	assert.Equal(t, "/originals/trip/day1/b.jpg", coverSource(trip, stats["/originals/trip"]))
+}
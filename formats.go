@@ -0,0 +1,279 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/anthonynsimon/bild/imgio"
+	"golang.org/x/image/tiff"
+
+	// Register the decoders for the input formats not covered by imgio
+	_ "golang.org/x/image/webp"
+)
+
+// imageFormat describes an image format we recognise as an original, and
+// optionally can write derived images in.
+type imageFormat struct {
+	Name       string
+	MIMEType   string
+	Extensions []string // The first extension is used for derived images
+	// Encoder returns an encoder for the given quality, or is nil if we can only decode the format
+	Encoder func(quality int) imgio.Encoder
+	// Variant is set for the formats worth offering in <picture> sources,
+	// which browsers supporting them prefer over the output format
+	Variant bool
+}
+
+// imageFormats lists the recognised image formats. Decoders for all of them
+// are registered with the image package, so imgio.Open can read them.
+var imageFormats = []imageFormat{
+	{
+		Name:       "jpeg",
+		MIMEType:   "image/jpeg",
+		Extensions: []string{".jpg", ".jpeg"},
+		Encoder:    func(quality int) imgio.Encoder { return opaqueEncoder(imgio.JPEGEncoder(quality)) },
+	},
+	{
+		Name:       "png",
+		MIMEType:   "image/png",
+		Extensions: []string{".png"},
+		Encoder:    func(int) imgio.Encoder { return imgio.PNGEncoder() },
+	},
+	{
+		Name:       "gif",
+		MIMEType:   "image/gif",
+		Extensions: []string{".gif"},
+		// Quantizing to the GIF palette drops transparency, so transparent images are flattened first
+		Encoder: func(int) imgio.Encoder {
+			return opaqueEncoder(func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) })
+		},
+	},
+	{
+		Name:       "webp",
+		MIMEType:   "image/webp",
+		Extensions: []string{".webp"},
+		// The pure-Go WebP encoder is lossless only, so quality doesn't apply, and
+		// photos come out larger than as JPEG, while graphics come out smaller
+		Encoder: func(int) imgio.Encoder {
+			return func(w io.Writer, img image.Image) error { return nativewebp.Encode(w, img, nil) }
+		},
+		Variant: true,
+	},
+	{
+		Name:       "tiff",
+		MIMEType:   "image/tiff",
+		Extensions: []string{".tif", ".tiff"},
+		Encoder: func(int) imgio.Encoder {
+			return func(w io.Writer, img image.Image) error {
+				return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+			}
+		},
+	},
+	{
+		Name:       "bmp",
+		MIMEType:   "image/bmp",
+		Extensions: []string{".bmp"},
+		Encoder:    func(int) imgio.Encoder { return imgio.BMPEncoder() },
+	},
+}
+
+// opaqueEncoder wraps an encoder of a format without transparency, drawing
+// transparent images onto a white background first, rather than letting the
+// encoder turn their transparent areas black.
+func opaqueEncoder(encoder imgio.Encoder) imgio.Encoder {
+	return func(w io.Writer, img image.Image) error {
+		return encoder(w, flatten(img))
+	}
+}
+
+// flatten returns an image drawn onto a white background, or the image itself if it's opaque.
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
+
+// formatByName returns the image format with the given name.
+func formatByName(name string) (imageFormat, bool) {
+	for _, format := range imageFormats {
+		if format.Name == strings.ToLower(name) {
+			return format, true
+		}
+	}
+	return imageFormat{}, false
+}
+
+// formatByFile returns the image format of a file, matching its extension case-insensitively.
+func formatByFile(name string) (imageFormat, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, format := range imageFormats {
+		for _, formatExt := range format.Extensions {
+			if ext == formatExt {
+				return format, true
+			}
+		}
+	}
+	return imageFormat{}, false
+}
+
+// variantFormats returns the names of the formats that can be offered as variants.
+func variantFormats() []string {
+	names := []string{}
+	for _, format := range imageFormats {
+		if format.Variant && format.Encoder != nil {
+			names = append(names, format.Name)
+		}
+	}
+	return names
+}
+
+// outputFormat returns the configured format for derived images.
+func outputFormat() imageFormat {
+	format, ok := formatByName(config.OutputFormat)
+	if !ok || format.Encoder == nil {
+		// LoadConfig validates the output format, so this only happens when config isn't loaded
+		format, _ = formatByName("jpeg")
+	}
+	return format
+}
+
+// outputFormats returns the formats derived images are written in: the output
+// format first, followed by the variant formats offered to browsers supporting them.
+func outputFormats() []imageFormat {
+	formats := []imageFormat{outputFormat()}
+	for _, name := range config.OutputFormats {
+		format, ok := formatByName(name)
+		if !ok || format.Encoder == nil || !format.Variant || format.Name == formats[0].Name {
+			continue
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// derivedName returns the file name of a derived image (e.g. "thumb" or "full")
+// of an original in the output format. Copied originals always keep their name.
+func derivedName(size string, name string) string {
+	if size == "full" && config.CopyOriginals {
+		return size + "_" + name
+	}
+	return derivedNameFor(size, name, outputFormat())
+}
+
+// derivedNameFor returns the file name of a derived image in the given format.
+// Originals already in that format keep their extension, others get the
+// extension of the format appended, so "IMG_1.JPG" becomes "thumb_IMG_1.JPG"
+// and "shot.png" becomes "thumb_shot.png.jpg".
+func derivedNameFor(size string, name string, format imageFormat) string {
+	if original, ok := formatByFile(name); ok && original.Name == format.Name {
+		return size + "_" + name
+	}
+	return size + "_" + name + format.Extensions[0]
+}
+
+// derivedFiles returns the file names of all images derived from an original.
+func derivedFiles(name string) []string {
+	files := []string{}
+	for _, size := range []string{"thumb", "full"} {
+		for i, format := range outputFormats() {
+			if i == 0 {
+				files = append(files, derivedName(size, name))
+			} else if size != "full" || !config.CopyOriginals {
+				files = append(files, derivedNameFor(size, name, format))
+			}
+		}
+	}
+	for _, size := range config.Sizes {
+		for _, format := range outputFormats() {
+			files = append(files, derivedNameFor(size.Name, name, format))
+		}
+	}
+	return files
+}
+
+// sizeWidth returns the width of a responsive size of an upright image with
+// the given width. Images are never upscaled, so sizes wider than the image
+// get its width. An unknown (zero) image width leaves the size as is.
+func sizeWidth(size ImageSize, width int) int {
+	if width > 0 && width < size.Width {
+		return width
+	}
+	return size.Width
+}
+
+// imageSources returns the responsive sizes in the given format of an
+// original with the given upright width.
+func imageSources(name string, format imageFormat, width int) []Source {
+	sources := []Source{}
+	for _, size := range config.Sizes {
+		sources = append(sources, Source{
+			Name:  size.Name,
+			Width: sizeWidth(size, width),
+			File:  derivedNameFor(size.Name, name, format),
+		})
+	}
+	return sources
+}
+
+// srcset returns the srcset attribute value for the given sources. Sources as
+// wide as an earlier one are left out, as the candidates need distinct widths.
+func srcset(sources []Source) string {
+	candidates := []string{}
+	widths := map[int]bool{}
+	for _, source := range sources {
+		if widths[source.Width] {
+			continue
+		}
+		widths[source.Width] = true
+		candidates = append(candidates, source.File+" "+strconv.Itoa(source.Width)+"w")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// fullSrcset returns the srcset attribute value for showing an image at full
+// size: the responsive sizes followed by the full size image, with the given
+// width. It's empty without responsive sizes, and leaves out the full size
+// image if its width is unknown (zero).
+func fullSrcset(sources []Source, full string, fullWidth int) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	if fullWidth > 0 {
+		sources = append(slices.Clone(sources), Source{Name: "full", Width: fullWidth, File: full})
+	}
+	return srcset(sources)
+}
+
+// imageVariants returns the variants of an original in the variant formats,
+// given its upright width and the width of its full size image.
+// Copied originals have no full size variants, so browsers get the original instead.
+func imageVariants(name string, width int, fullWidth int) []Variant {
+	variants := []Variant{}
+	for _, format := range outputFormats()[1:] {
+		variant := Variant{
+			Type:    format.MIMEType,
+			Thumb:   derivedNameFor("thumb", name, format),
+			Full:    derivedName("full", name),
+			Sources: imageSources(name, format, width),
+		}
+		if !config.CopyOriginals {
+			variant.Full = derivedNameFor("full", name, format)
+		}
+		variant.Srcset = srcset(variant.Sources)
+		variant.FullSrcset = fullSrcset(variant.Sources, variant.Full, fullWidth)
+		variants = append(variants, variant)
+	}
+	return variants
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatByFile(t *testing.T) {
+	tests := map[string]string{
+		"image.jpg":    "jpeg",
+		"IMG_0001.JPG": "jpeg",
+		"image.jpeg":   "jpeg",
+		"shot.PNG":     "png",
+		"anim.gif":     "gif",
+		"photo.webp":   "webp",
+		"scan.tif":     "tiff",
+		"scan.TIFF":    "tiff",
+		"old.bmp":      "bmp",
+	}
+	for name, expected := range tests {
+		format, ok := formatByFile(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expected, format.Name, name)
+	}
+
+	_, ok := formatByFile("notes.txt")
+	assert.False(t, ok)
+	_, ok = formatByFile("jpg")
+	assert.False(t, ok)
+}
+
+func TestOpaqueEncoder(t *testing.T) {
+	// An image that is transparent on the left and red on the right
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for x := 8; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	// Encoding it as JPEG draws it onto white instead of black
+	format, _ := formatByName("jpeg")
+	var buf bytes.Buffer
+	err := format.Encoder(90)(&buf, img)
+	assert.NoError(t, err)
+	decoded, err := jpeg.Decode(&buf)
+	assert.NoError(t, err)
+	r, g, b, _ := decoded.At(2, 4).RGBA()
+	assert.Greater(t, r>>8, uint32(240))
+	assert.Greater(t, g>>8, uint32(240))
+	assert.Greater(t, b>>8, uint32(240))
+	r, g, _, _ = decoded.At(13, 4).RGBA()
+	assert.Greater(t, r>>8, uint32(200))
+	assert.Less(t, g>>8, uint32(60))
+
+	// Opaque images are encoded as they are
+	opaque := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	opaque.Set(0, 0, color.Black)
+	assert.Same(t, opaque, flatten(opaque))
+}
+
+func TestDerivedName(t *testing.T) {
+	config.OutputFormat = "jpeg"
+	config.CopyOriginals = false
+
+	assert.Equal(t, "thumb_image.jpg", derivedName("thumb", "image.jpg"))
+	assert.Equal(t, "full_IMG_0001.JPG", derivedName("full", "IMG_0001.JPG"))
+	assert.Equal(t, "thumb_shot.png.jpg", derivedName("thumb", "shot.png"))
+	assert.Equal(t, "full_photo.webp.jpg", derivedName("full", "photo.webp"))
+
+	// Copied originals keep their name
+	config.CopyOriginals = true
+	assert.Equal(t, "thumb_shot.png.jpg", derivedName("thumb", "shot.png"))
+	assert.Equal(t, "full_shot.png", derivedName("full", "shot.png"))
+	config.CopyOriginals = false
+
+	// Originals already in the output format keep their extension
+	config.OutputFormat = "png"
+	assert.Equal(t, "thumb_shot.png", derivedName("thumb", "shot.png"))
+	assert.Equal(t, "thumb_image.jpg.png", derivedName("thumb", "image.jpg"))
+	config.OutputFormat = "jpeg"
+}
+
+func TestDerivedFilesAndVariants(t *testing.T) {
+	config.OutputFormat = "jpeg"
+	config.OutputFormats = []string{"webp", "png", "jpeg"}
+	config.CopyOriginals = false
+	defer func() { config.OutputFormats = []string{} }()
+
+	// The output format is listed first, and only once, followed by the variant formats
+	assert.Equal(t, []string{"jpeg", "webp"}, []string{outputFormats()[0].Name, outputFormats()[1].Name})
+	assert.Len(t, outputFormats(), 2)
+
+	assert.Equal(t, []string{
+		"thumb_image.jpg", "thumb_image.jpg.webp",
+		"full_image.jpg", "full_image.jpg.webp",
+	}, derivedFiles("image.jpg"))
+	assert.Equal(t, []Variant{
+		{Type: "image/webp", Thumb: "thumb_image.jpg.webp", Full: "full_image.jpg.webp", Sources: []Source{}},
+	}, imageVariants("image.jpg", 0, 0))
+
+	// Copied originals have no full size variants
+	config.CopyOriginals = true
+	assert.Equal(t, []string{
+		"thumb_image.jpg", "thumb_image.jpg.webp",
+		"full_image.jpg",
+	}, derivedFiles("image.jpg"))
+	assert.Equal(t, []Variant{
+		{Type: "image/webp", Thumb: "thumb_image.jpg.webp", Full: "full_image.jpg", Sources: []Source{}},
+	}, imageVariants("image.jpg", 0, 0))
+	config.CopyOriginals = false
+}
+
+func TestImageSourcesAndSrcset(t *testing.T) {
+	config.OutputFormat = "jpeg"
+	config.Sizes = []ImageSize{{Name: "w480", Width: 480}, {Name: "large", Width: 1600}}
+	defer func() { config.Sizes = []ImageSize{} }()
+
+	jpeg, _ := formatByName("jpeg")
+	webp, _ := formatByName("webp")
+	sources := imageSources("shot.png", jpeg, 2000)
+	assert.Equal(t, []Source{
+		{Name: "w480", Width: 480, File: "w480_shot.png.jpg"},
+		{Name: "large", Width: 1600, File: "large_shot.png.jpg"},
+	}, sources)
+	assert.Equal(t, "w480_shot.png.jpg 480w, large_shot.png.jpg 1600w", srcset(sources))
+	assert.Equal(t, "w480_image.jpg.webp 480w, large_image.jpg.webp 1600w", srcset(imageSources("image.jpg", webp, 0)))
+	assert.Equal(t, "", srcset([]Source{}))
+
+	// The lightbox also gets the full size image
+	assert.Equal(t, "w480_shot.png.jpg 480w, large_shot.png.jpg 1600w, full_shot.png.jpg 2000w", fullSrcset(sources, "full_shot.png.jpg", 2000))
+	assert.Equal(t, "w480_shot.png.jpg 480w, large_shot.png.jpg 1600w", fullSrcset(sources, "full_shot.png.jpg", 0))
+	assert.Equal(t, "", fullSrcset([]Source{}, "full_shot.png.jpg", 2000))
+
+	// Sizes wider than the original get its width, listed only once
+	sources = imageSources("shot.png", jpeg, 1000)
+	assert.Equal(t, 1000, sources[1].Width)
+	assert.Equal(t, "w480_shot.png.jpg 480w, large_shot.png.jpg 1000w", fullSrcset(sources, "full_shot.png.jpg", 1000))
+
+	assert.Contains(t, derivedFiles("image.jpg"), "w480_image.jpg")
+	assert.Contains(t, derivedFiles("image.jpg"), "large_image.jpg")
+}
@@ -3,13 +3,15 @@ module github.com/tkjaer/gallery
 go 1.24.1
 
 require (
+	github.com/HugoSmits86/nativewebp v0.9.3
 	github.com/anthonynsimon/bild v0.14.0
 	github.com/stretchr/testify v1.10.0
+	github.com/yuin/goldmark v1.8.6
+	golang.org/x/image v0.18.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/image v0.18.0 // indirect
 )
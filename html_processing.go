@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -12,115 +19,313 @@ import (
 
 // processHTMLFile is called when an HTML file is found that needs to be processed.
 // It will index the directory content, and generate a new index file in the
-// output directory.
-func processHTMLFile(htmlTasks <-chan Dir, wg *sync.WaitGroup, done <-chan struct{}) {
+// output directory. Directories that fail to process are reported on the errors channel.
+// It returns once the tasks channel is closed, or the context is cancelled.
+func processHTMLFile(ctx context.Context, htmlTasks <-chan Dir, errs chan<- error, wg *sync.WaitGroup) {
 	slog.Debug("Starting processHTML goroutine")
 	defer wg.Done()
 
-	tpl, err := template.ParseGlob(filepath.Join("templates", config.Template, "index.go.html"))
-	if err != nil {
-		slog.Error("Failed to parse template", "error", err)
-		return
+	// A template that fails to parse fails every page, but the tasks are still
+	// received, so the walk isn't blocked
+	tpl, tplErr := parseTemplate("index.go.html")
+	var imageTpl *template.Template
+	if tplErr == nil && config.ImagePages {
+		imageTpl, tplErr = parseTemplate("image.go.html")
+	}
+	if tplErr == nil {
+		slog.Debug("Template parsed", "template", tpl)
 	}
-	slog.Debug("Template parsed", "template", tpl)
 
 	for {
 		select {
-		case htmlTask := <-htmlTasks:
+		case htmlTask, ok := <-htmlTasks:
+			if !ok {
+				slog.Debug("HTML tasks channel closed")
+				return
+			}
 			slog.Debug("Received HTML task", "htmlTask", htmlTask)
-
-			navigation := []NavigationElement{}
-			images := []Image{}
-			folders := []string{}
-			fileIndex := 0
-
-			imagePath := strings.TrimPrefix(htmlTask.Path, config.Originals)
-			imagePath = strings.TrimPrefix(imagePath, "/")
-			outputDir := filepath.Join(config.Output, imagePath)
-			outputFile := filepath.Join(outputDir, "index.html")
-
-			for _, image := range htmlTask.Files {
-				fileIndex += 1
-				images = append(images, Image{
-					Description: image.Name,
-					File:        image.Name,
-					Path:        imagePath,
-					Index:       fileIndex,
-				})
-				slog.Debug("Image added", "image", image.Name, "path", imagePath)
+			if tplErr != nil {
+				errs <- &fileError{Path: htmlTask.Path, Err: fmt.Errorf("failed to parse template: %w", tplErr)}
+				continue
 			}
-
-			navigationParts := strings.Split(imagePath, "/")
-			for i := range navigationParts {
-				slog.Debug("Processing navigation part", "navigationPart", navigationParts[i])
-				navigation = append(navigation, NavigationElement{
-					Path: strings.Join(navigationParts[:i+1], "/"),
-					Name: navigationParts[i],
-				})
-				slog.Debug("Directory added", "path", navigationParts[i])
+			err := generateHTML(tpl, imageTpl, htmlTask)
+			if err != nil {
+				errs <- &fileError{Path: htmlTask.Path, Err: err}
 			}
 
-			for _, subDir := range htmlTask.SubDirs {
-				slog.Debug("Processing subdirectory", "subDir", subDir.Name)
-				folders = append(folders, subDir.Name)
-				slog.Debug("Subdirectory added", "subDir", subDir.Name)
-			}
+		case <-ctx.Done():
+			slog.Debug("Build cancelled, stopping processHTML goroutine")
+			return
+		}
+	}
+}
 
-			// Sort images based on the specified order
-			switch config.ImageOrder {
-			case "alphabetical":
-				slog.Debug("Sorting images alphabetically")
-				// Images are already in alphabetical order by default
-			case "new":
-				slog.Debug("Sorting images by newest first")
-				// Sort images by ModTime in descending order
-				sort.SliceStable(images, func(i, j int) bool {
-					return htmlTask.Files[images[i].File].ModTime.After(htmlTask.Files[images[j].File].ModTime)
-				})
-			case "old":
-				slog.Debug("Sorting images by oldest first")
-				// Sort images by ModTime in ascending order
-				sort.SliceStable(images, func(i, j int) bool {
-					return htmlTask.Files[images[i].File].ModTime.Before(htmlTask.Files[images[j].File].ModTime)
-				})
-			}
+// generateHTML generates the index page of a directory, and the image pages
+// unless imageTpl is nil, and records them in the manifest.
+func generateHTML(tpl *template.Template, imageTpl *template.Template, htmlTask Dir) error {
+	navigation := []NavigationElement{}
+	images := []Image{}
+	folders := []Folder{}
 
-			g := Gallery{
-				Name:        config.Name,
-				Copyright:   config.Copyright,
-				Folders:     folders,
-				Navigation:  navigation,
-				Images:      images,
-				Year:        year,
-				GalleryPath: config.GalleryPath,
-			}
-			slog.Debug("Gallery object created", "gallery", g)
+	imagePath := strings.TrimPrefix(htmlTask.Path, config.Originals)
+	imagePath = strings.TrimPrefix(imagePath, "/")
+	outputDir := filepath.Join(config.Output, imagePath)
+	outputFile := filepath.Join(outputDir, "index.html")
 
-			err := os.MkdirAll(filepath.Join(outputDir), 0755)
-			if err != nil {
-				slog.Error("Failed to create output directory", "error", err)
-				os.Exit(1)
-			}
-			slog.Debug("Output directory created", "outputDir", outputDir)
+	// Sort images based on the order of the folder, or the global one
+	imageOrder := htmlTask.Folder.ImageOrder
+	if imageOrder == "" {
+		imageOrder = config.ImageOrder
+	}
+	files := slices.Collect(maps.Values(htmlTask.Files))
+	sortFiles(files, imageOrder, htmlTask.Folder.Order)
+	slog.Debug("Images sorted", "order", imageOrder)
 
-			f, err := os.Create(outputFile)
-			if err != nil {
-				slog.Error("Failed to create output file", "error", err)
-				os.Exit(1)
-			}
-			defer f.Close()
-			slog.Debug("Output file created", "outputFile", outputFile)
+	for i, image := range files {
+		// The responsive sizes depend on the dimensions of the original, so they're only read if there are any
+		width, fullWidth := 0, 0
+		if len(config.Sizes) > 0 {
+			original := filepath.Join(htmlTask.Path, image.Name)
+			width, _ = uprightSize(original, image.Metadata.Orientation)
+			fullWidth, _ = fullImageSize(original, image.Metadata.Orientation)
+		}
+		sources := imageSources(image.Name, outputFormat(), width)
+		full := derivedName("full", image.Name)
+		images = append(images, Image{
+			Description: image.Metadata.description(image.Name),
+			File:        image.Name,
+			Thumb:       derivedName("thumb", image.Name),
+			Full:        full,
+			Page:        imagePageName(image.Name),
+			Variants:    imageVariants(image.Name, width, fullWidth),
+			Sources:     sources,
+			Srcset:      srcset(sources),
+			FullSrcset:  fullSrcset(sources, full, fullWidth),
+			Path:        imagePath,
+			Metadata:    image.Metadata,
+			Index:       i + 1,
+		})
+		slog.Debug("Image added", "image", image.Name, "path", imagePath)
+	}
 
-			err = tpl.ExecuteTemplate(f, "index.go.html", g)
-			if err != nil {
-				slog.Error("Failed to execute template", "error", err)
-				os.Exit(1)
+	navigationParts := strings.Split(imagePath, "/")
+	for i := range navigationParts {
+		slog.Debug("Processing navigation part", "navigationPart", navigationParts[i])
+		navigation = append(navigation, NavigationElement{
+			Path: strings.Join(navigationParts[:i+1], "/"),
+			Name: navigationParts[i],
+		})
+		slog.Debug("Directory added", "path", navigationParts[i])
+	}
+
+	// Hidden subdirectories are generated, but not listed
+	subDirs := []SubDir{}
+	for _, subDir := range htmlTask.SubDirs {
+		if subDir.Hidden {
+			slog.Debug("Skipping hidden subdirectory", "subDir", subDir.Name)
+			continue
+		}
+		subDirs = append(subDirs, subDir)
+	}
+	// Sort subdirectories by their sort position, then in the image order
+	sortSubDirs(subDirs, imageOrder, htmlTask.Folder.Order)
+	for _, subDir := range subDirs {
+		folder := Folder{
+			Name:   subDir.Name,
+			Title:  subDir.Title,
+			Link:   relativeURL(subDir.Name + "/"),
+			Images: subDir.Images,
+			Latest: subDir.Latest,
+		}
+		if folder.Title == "" {
+			folder.Title = subDir.Name
+		}
+		if subDir.Cover != "" {
+			folder.Cover = relativeURL(subDir.Name + "/" + subDir.Cover)
+		}
+		folders = append(folders, folder)
+		slog.Debug("Subdirectory added", "subDir", subDir.Name)
+	}
+
+	description, err := renderMarkdown(htmlTask.Folder.Description)
+	if err != nil {
+		return fmt.Errorf("failed to render folder description: %w", err)
+	}
+
+	// The title of the folder, for image pages and structured data
+	folderTitle := htmlTask.Folder.Title
+	switch {
+	case folderTitle != "":
+	case imagePath == "":
+		folderTitle = config.Name
+	default:
+		folderTitle = htmlTask.Name
+	}
+
+	feeds := feedLinks(htmlTask)
+	g := Gallery{
+		Name:        config.Name,
+		Title:       htmlTask.Folder.Title,
+		Description: description,
+		Summary:     plainText(description),
+		Cover:       htmlTask.Cover,
+		Copyright:   config.Copyright,
+		Folders:     folders,
+		Navigation:  navigation,
+		Images:      images,
+		Feeds:       feeds,
+		Year:        year,
+		GalleryPath: config.GalleryPath,
+	}
+	if config.GalleryURL != "" {
+		g.URL = folderURL(imagePath)
+		gallery := schemaImageGallery{
+			Context:     schemaContext,
+			Type:        "ImageGallery",
+			Name:        folderTitle,
+			Description: g.Summary,
+			URL:         g.URL,
+		}
+		// The full size image of the cover represents the folder
+		if source := htmlTask.CoverSource; source != "" {
+			metadata, ok := htmlTask.Files[source]
+			if !ok {
+				metadata.Metadata, _ = readMetadata(source)
 			}
-			slog.Debug("Template executed", "outputFile", outputFile)
+			g.ImageURL = absoluteURL(manifestKey(filepath.Dir(source)), derivedName("full", filepath.Base(source)))
+			g.ImageWidth, g.ImageHeight = fullImageSize(source, metadata.Metadata.Orientation)
+			gallery.Image = &schemaImageObject{Type: "ImageObject", ContentURL: g.ImageURL, Width: g.ImageWidth, Height: g.ImageHeight}
+		}
+		for _, image := range images {
+			gallery.AssociatedMedia = append(gallery.AssociatedMedia, newSchemaImageObject(image))
+		}
+		g.JSONLD = jsonLD(gallery)
+	}
+	slog.Debug("Gallery object created", "gallery", g)
 
-		case <-done:
-			slog.Debug("Received done signal")
-			return
+	err = os.MkdirAll(filepath.Join(outputDir), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	slog.Debug("Output directory created", "outputDir", outputDir)
+
+	err = writeFileAtomic(outputFile, func(w io.Writer) error {
+		return tpl.ExecuteTemplate(w, "index.go.html", g)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write index page: %w", err)
+	}
+	slog.Debug("Template executed", "outputFile", outputFile)
+
+	if imageTpl != nil {
+		err = generateImagePages(imageTpl, images, folderTitle, navigation, feeds, htmlTask.Path, outputDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest.setPage(htmlTask.Path, ManifestPage{
+		Hash:  htmlTask.Hash,
+		Files: outputFiles(outputDir, pageFiles(htmlTask)),
+	})
+	return nil
+}
+
+// generateImagePages generates the page of every image of a directory, in
+// the given order, linking each to the previous and next one, and to the
+// feeds of the directory.
+func generateImagePages(tpl *template.Template, images []Image, folder string, navigation []NavigationElement, feeds []FeedLink, originalDir string, outputDir string) error {
+	for i, image := range images {
+		page := ImagePage{
+			Name:        config.Name,
+			Title:       image.Metadata.title(image.File),
+			Folder:      folder,
+			Copyright:   config.Copyright,
+			Image:       image,
+			Navigation:  navigation,
+			Up:          "./",
+			URL:         absoluteURL(image.Path, image.Page),
+			ImageURL:    absoluteURL(image.Path, image.Full),
+			Feeds:       feeds,
+			Year:        year,
+			GalleryPath: config.GalleryPath,
+		}
+		if config.GalleryURL != "" {
+			page.ImageWidth, page.ImageHeight = fullImageSize(filepath.Join(originalDir, image.File), image.Metadata.Orientation)
+			object := newSchemaImageObject(image)
+			object.Context = schemaContext
+			object.Width = page.ImageWidth
+			object.Height = page.ImageHeight
+			page.JSONLD = jsonLD(object)
+		}
+		if i > 0 {
+			page.Prev = relativeURL(images[i-1].Page)
 		}
+		if i < len(images)-1 {
+			page.Next = relativeURL(images[i+1].Page)
+		}
+
+		pageFile := filepath.Join(outputDir, image.Page)
+		err := writeFileAtomic(pageFile, func(w io.Writer) error {
+			return tpl.ExecuteTemplate(w, "image.go.html", page)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write image page %s: %w", image.Page, err)
+		}
+		slog.Debug("Image page written", "pageFile", pageFile)
+	}
+	return nil
+}
+
+// imagePageName returns the file name of the page of an image. It keeps the
+// extension of the original, like the derived images, so images differing
+// only in their extension get distinct pages.
+func imagePageName(name string) string {
+	if !config.ImagePages {
+		return ""
+	}
+	return name + ".html"
+}
+
+// relativeURL returns the URL of a file in the same directory, percent-encoded,
+// as file names may contain characters like # and ? that would end the path.
+func relativeURL(name string) string {
+	// String prefixes names that would be taken as a scheme, like "a:b.jpg", with "./"
+	fileURL := url.URL{Path: name}
+	return fileURL.String()
+}
+
+// pageFiles returns the file names of the pages generated for a directory:
+// its index page, followed by its image pages if enabled.
+func pageFiles(dir Dir) []string {
+	files := []string{"index.html"}
+	for _, file := range dir.Files {
+		if page := imagePageName(file.Name); page != "" {
+			files = append(files, page)
+		}
+	}
+	sort.Strings(files[1:])
+	return files
+}
+
+// folderURL returns the absolute URL of the index page of a directory, given
+// its path relative to the gallery path, or an empty string without a gallery URL.
+func folderURL(dir string) string {
+	link := absoluteURL(dir, "")
+	if link != "" && !strings.HasSuffix(link, "/") {
+		link += "/"
+	}
+	return link
+}
+
+// absoluteURL returns the absolute URL of a file in the gallery, given its
+// directory relative to the gallery path, or an empty string without a gallery
+// URL. The path is percent-encoded, as folder and file names may contain
+// spaces, or characters like # and ? that would end it.
+func absoluteURL(dir string, name string) string {
+	if config.GalleryURL == "" {
+		return ""
 	}
+	pathURL := url.URL{Path: path.Join("/", config.GalleryPath, dir, name)}
+	return strings.TrimSuffix(config.GalleryURL, "/") + pathURL.EscapedPath()
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,10 +13,8 @@ import (
 )
 
 func TestProcessHTMLFile(t *testing.T) {
-	// Set up temporary directories for testing
-	tempDir := t.TempDir()
-	config.Output = filepath.Join(tempDir, "output")
-	config.Originals = filepath.Join(tempDir, "originals")
+	// Start from the default configuration, with temporary directories for testing
+	tempDir := setupTestConfig(t)
 	config.Name = "Test Gallery"
 	config.Copyright = "© 2025 Test"
 	config.GalleryPath = "/gallery"
@@ -44,13 +43,13 @@ func TestProcessHTMLFile(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Set up channels and WaitGroup
+	errs := make(chan error, 10)
 	htmlTasks := make(chan Dir)
-	done := make(chan struct{})
 	var wg sync.WaitGroup
 
 	// Start the processHTMLFile function in a goroutine
 	wg.Add(1)
-	go processHTMLFile(htmlTasks, &wg, done)
+	go processHTMLFile(context.Background(), htmlTasks, errs, &wg)
 
 	// Create a mock HTML task
 	htmlTask := Dir{
@@ -67,9 +66,10 @@ func TestProcessHTMLFile(t *testing.T) {
 	// Send the task to the channel
 	htmlTasks <- htmlTask
 
-	close(done)
+	close(htmlTasks)
 	// Wait for the goroutine to finish
 	wg.Wait()
+	assert.Empty(t, errs)
 
 	// Verify the output directory was created
 	outputDir := filepath.Join(config.Output, "test")
@@ -91,10 +91,8 @@ func TestProcessHTMLFile(t *testing.T) {
 }
 
 func TestProcessHTMLFileWithNewestFirst(t *testing.T) {
-	// Set up temporary directories for testing
-	tempDir := t.TempDir()
-	config.Output = filepath.Join(tempDir, "output")
-	config.Originals = filepath.Join(tempDir, "originals")
+	// Start from the default configuration, with temporary directories for testing
+	tempDir := setupTestConfig(t)
 	config.Name = "Test Gallery"
 	config.Copyright = "© 2025 Test"
 	config.GalleryPath = "/gallery"
@@ -123,13 +121,13 @@ func TestProcessHTMLFileWithNewestFirst(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Set up channels and WaitGroup
+	errs := make(chan error, 10)
 	htmlTasks := make(chan Dir)
-	done := make(chan struct{})
 	var wg sync.WaitGroup
 
 	// Start the processHTMLFile function in a goroutine
 	wg.Add(1)
-	go processHTMLFile(htmlTasks, &wg, done)
+	go processHTMLFile(context.Background(), htmlTasks, errs, &wg)
 
 	// Create a mock HTML task
 	htmlTask := Dir{
@@ -152,7 +150,7 @@ func TestProcessHTMLFileWithNewestFirst(t *testing.T) {
 	// Send the task to the channel
 	htmlTasks <- htmlTask
 
-	close(done)
+	close(htmlTasks)
 	// Wait for the goroutine to finish
 	wg.Wait()
 
@@ -177,3 +175,78 @@ func TestProcessHTMLFileWithNewestFirst(t *testing.T) {
 	// Verify the order of images (newest first)
 	assert.True(t, strings.Index(string(content), "image2.jpg") < strings.Index(string(content), "image1.jpg"))
 }
+
+func TestProcessHTMLFileWithMetadata(t *testing.T) {
+	// Set up temporary directories for testing
+	tempDir := t.TempDir()
+	config.Output = filepath.Join(tempDir, "output")
+	config.Originals = filepath.Join(tempDir, "originals")
+	config.Template = "default"
+	config.ImageOrder = "new"
+
+	// Set up channels and WaitGroup
+	errs := make(chan error, 10)
+	htmlTasks := make(chan Dir)
+	var wg sync.WaitGroup
+
+	// Start the processHTMLFile function in a goroutine
+	wg.Add(1)
+	go processHTMLFile(context.Background(), htmlTasks, errs, &wg)
+
+	// Create a mock HTML task with an image carrying EXIF metadata
+	htmlTasks <- Dir{
+		Path: "/test",
+		Files: map[string]File{
+			"image1.jpg": {
+				Name: "image1.jpg",
+				Metadata: Metadata{
+					EXIF: [][]string{{"Camera", "Canon EOS R5"}, {"Aperture", "f/1.8"}, {"Lens", "<script>alert(1)</script>"}},
+				},
+			},
+		},
+	}
+
+	close(htmlTasks)
+	// Wait for the goroutine to finish
+	wg.Wait()
+
+	// Verify the shooting information is rendered in the lightbox
+	content, err := os.ReadFile(filepath.Join(config.Output, "test", "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<li title="Camera">Canon EOS R5</li>`)
+	assert.Contains(t, string(content), `<li title="Aperture">f/1.8</li>`)
+
+	// Strings read from the originals are escaped
+	assert.Contains(t, string(content), `<li title="Lens">&lt;script&gt;alert(1)&lt;/script&gt;</li>`)
+	assert.NotContains(t, string(content), "<script>alert(1)")
+}
+
+func TestAbsoluteURL(t *testing.T) {
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/gallery/"
+	t.Cleanup(func() {
+		config.GalleryURL = ""
+		config.GalleryPath = "/"
+	})
+
+	assert.Equal(t, "https://example.com/gallery/trip/full_a.jpg", absoluteURL("trip", "full_a.jpg"))
+	assert.Equal(t, "https://example.com/gallery/", folderURL(""))
+
+	// Folder and file names are percent-encoded
+	assert.Equal(t, "https://example.com/gallery/My%20Album/", folderURL("My Album"))
+	assert.Equal(t, "https://example.com/gallery/My%20Album/IMG%231%3F.jpg.html", absoluteURL("My Album", "IMG#1?.jpg.html"))
+
+	// Without a gallery URL there are no absolute URLs
+	config.GalleryURL = ""
+	assert.Equal(t, "", absoluteURL("trip", "full_a.jpg"))
+	assert.Equal(t, "", folderURL("trip"))
+}
+
+func TestRelativeURL(t *testing.T) {
+	assert.Equal(t, "IMG_1.jpg.html", relativeURL("IMG_1.jpg.html"))
+	assert.Equal(t, "IMG%231.jpg.html", relativeURL("IMG#1.jpg.html"))
+	assert.Equal(t, "a%3Fb.jpg.html", relativeURL("a?b.jpg.html"))
+	assert.Equal(t, "My%20photo.jpg.html", relativeURL("My photo.jpg.html"))
+	// Names that would be taken as a scheme are made relative
+	assert.Equal(t, "./a:b.jpg.html", relativeURL("a:b.jpg.html"))
+}
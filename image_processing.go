@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"image"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -8,109 +11,337 @@ import (
 	"sync"
 	"time"
 
+	"github.com/anthonynsimon/bild/clone"
 	"github.com/anthonynsimon/bild/imgio"
 	"github.com/anthonynsimon/bild/transform"
 )
 
 // processImage is called when an image is found that needs to be processed.
-// It will resize the image, and copy it to the output directory.
-func processImage(imageTasks <-chan string, RSSTasks chan<- RSSItem, wg *sync.WaitGroup, done <-chan struct{}) {
+// It will resize the image, and copy it to the output directory. Images that
+// fail to process are reported on the errors channel. It returns once the tasks
+// channel is closed, or the context is cancelled.
+func processImage(ctx context.Context, imageTasks <-chan imageTask, RSSTasks chan<- RSSItem, errs chan<- error, wg *sync.WaitGroup) {
 	slog.Debug("Starting processImage goroutine")
 	defer wg.Done()
-	var file string
 	for {
 		select {
-		case file = <-imageTasks:
-			if file == "" {
+		case task, ok := <-imageTasks:
+			if !ok {
+				slog.Debug("Image tasks channel closed")
+				return
+			}
+			if task.Path == "" {
 				slog.Debug("Received empty file path, skipping")
 				continue
 			}
-			slog.Debug("Received image task", "file", file)
-
-			imgName := filepath.Base(file)
-			outputDir := filepath.Join(config.Output, filepath.Dir(strings.TrimPrefix(file, config.Originals)))
+			slog.Debug("Received image task", "file", task.Path)
 
-			img, err := imgio.Open(file)
+			item, err := generateImage(ctx, task)
 			if err != nil {
-				slog.Error("Failed to open image", "file", file, "error", err)
-				os.Exit(1)
+				if ctx.Err() == nil {
+					errs <- &fileError{Path: task.Path, Err: err}
+				}
+				continue
 			}
-			slog.Debug("Image opened", "file", file)
-			// calculate height, depending on the aspect ratio and the config.ThumbSize
-			width := img.Bounds().Max.X
-			height := img.Bounds().Max.Y
-			slog.Debug("Image dimensions", "width", width, "height", height)
-			aspectRatio := float64(width) / float64(height)
-			thumbWidth := config.ThumbSize
-			thumbHeight := int(float64(thumbWidth) / aspectRatio)
-			slog.Debug("Aspect ratio calculated", "aspectRatio", aspectRatio, "thumbWidth", thumbWidth, "thumbHeight", thumbHeight)
-
-			err = os.MkdirAll(outputDir, 0755)
-			if err != nil {
-				slog.Error("Failed to create output directory", "error", err)
-				os.Exit(1)
+
+			// Now that the image is processed, we can add it to its feeds,
+			// unless it isn't part of any, as its folder is hidden
+			if len(item.Feeds) == 0 {
+				continue
 			}
-			slog.Debug("Output directory created", "outputDir", outputDir)
-
-			// Generate thumbnail
-			thumb := transform.Resize(img, config.ThumbSize, thumbHeight, transform.Lanczos)
-			slog.Debug("Thumbnail resized", "thumbSize", config.ThumbSize)
-			if err := imgio.Save(filepath.Join(outputDir, "thumb_"+imgName), thumb, imgio.JPEGEncoder(config.JPEGQuality)); err != nil {
-				slog.Error("Failed to save thumbnail", "error", err)
-				os.Exit(1)
+			select {
+			case RSSTasks <- item:
+			case <-ctx.Done():
 			}
-			slog.Debug("Thumbnail saved", "thumbFile", filepath.Join(outputDir, "thumb_"+imgName))
-
-			// Copy original or generate full image
-			if config.CopyOriginals {
-				slog.Debug("Copying original file", "file", file)
-				err := copyFile(file, filepath.Join(outputDir, "full_"+imgName))
-				if err != nil {
-					slog.Error("Failed to copy original file", "error", err)
-					os.Exit(1)
-				}
-				slog.Debug("Original file copied", "file", file)
-
-			} else {
-				// calculate full size, depending on the aspect ratio and the config.FullSize
-				// config.FullSize designates the longest side of the image
-				fullWidth := config.FullSize
-				fullHeight := int(float64(config.FullSize) / aspectRatio)
-				if aspectRatio < 1 {
-					fullWidth = int(float64(config.FullSize) * aspectRatio)
-					fullHeight = config.FullSize
-				}
-				full := transform.Resize(img, fullWidth, fullHeight, transform.Linear)
-				slog.Debug("Full image resized", "fullSize", config.FullSize)
-				if err := imgio.Save(filepath.Join(outputDir, "full_"+imgName), full, imgio.JPEGEncoder(config.JPEGQuality)); err != nil {
-					slog.Error("Failed to save full image", "error", err)
-					os.Exit(1)
-				}
-				slog.Debug("Full image saved", "fullFile", filepath.Join(outputDir, "full_"+imgName))
+
+		case <-ctx.Done():
+			slog.Debug("Build cancelled, stopping processImage goroutine")
+			return
+		}
+	}
+}
+
+// generateImage generates the derived images of an original, and records them
+// in the manifest. It returns the RSS item of the image, which is empty if it
+// isn't part of any feed. A cancelled context stops it between writing the
+// derived images, leaving the image unrecorded.
+func generateImage(ctx context.Context, task imageTask) (RSSItem, error) {
+	file := task.Path
+	imgName := filepath.Base(file)
+	outputDir := filepath.Join(config.Output, filepath.Dir(strings.TrimPrefix(file, config.Originals)))
+
+	img, err := imgio.Open(file)
+	if err != nil {
+		return RSSItem{}, fmt.Errorf("failed to open image: %w", err)
+	}
+	slog.Debug("Image opened", "file", file)
+
+	// Rotate and flip the image according to its EXIF orientation, so
+	// the aspect ratio below is calculated on the corrected dimensions
+	metadata, err := readMetadata(file)
+	if err != nil {
+		slog.Warn("Failed to read image metadata", "file", file, "error", err)
+	}
+	img = applyOrientation(img, metadata.Orientation)
+
+	// The thumbnail size of the folder, or the global one
+	thumbSize := task.ThumbSize
+	if thumbSize == 0 {
+		thumbSize = config.ThumbSize
+	}
+
+	// calculate height, depending on the aspect ratio and the thumbnail size
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	slog.Debug("Image dimensions", "width", width, "height", height)
+	aspectRatio := float64(width) / float64(height)
+	thumbWidth := thumbSize
+	thumbHeight := int(float64(thumbWidth) / aspectRatio)
+	slog.Debug("Aspect ratio calculated", "aspectRatio", aspectRatio, "thumbWidth", thumbWidth, "thumbHeight", thumbHeight)
+
+	err = os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return RSSItem{}, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	slog.Debug("Output directory created", "outputDir", outputDir)
+
+	// Generate thumbnail, in the output format and every variant format
+	thumb := transform.Resize(img, thumbWidth, thumbHeight, transform.Lanczos)
+	slog.Debug("Thumbnail resized", "thumbSize", thumbWidth)
+	for _, format := range outputFormats() {
+		if err := ctx.Err(); err != nil {
+			return RSSItem{}, err
+		}
+		thumbFile := filepath.Join(outputDir, derivedNameFor("thumb", imgName, format))
+		if err := saveImage(thumbFile, thumb, format.Encoder(config.JPEGQuality)); err != nil {
+			return RSSItem{}, fmt.Errorf("failed to save thumbnail: %w", err)
+		}
+		slog.Debug("Thumbnail saved", "thumbFile", thumbFile)
+	}
+
+	// Copy original or generate full image
+	if config.CopyOriginals {
+		slog.Debug("Copying original file", "file", file)
+		err := copyFile(file, filepath.Join(outputDir, derivedName("full", imgName)))
+		if err != nil {
+			return RSSItem{}, fmt.Errorf("failed to copy original file: %w", err)
+		}
+		slog.Debug("Original file copied", "file", file)
+
+	} else {
+		fullWidth, fullHeight := fullDimensions(width, height)
+		full := transform.Resize(img, fullWidth, fullHeight, transform.Linear)
+		slog.Debug("Full image resized", "fullSize", config.FullSize)
+		for _, format := range outputFormats() {
+			if err := ctx.Err(); err != nil {
+				return RSSItem{}, err
+			}
+			fullFile := filepath.Join(outputDir, derivedNameFor("full", imgName, format))
+			if err := saveImage(fullFile, full, format.Encoder(config.JPEGQuality)); err != nil {
+				return RSSItem{}, fmt.Errorf("failed to save full image: %w", err)
 			}
+			slog.Debug("Full image saved", "fullFile", fullFile)
+		}
+	}
 
-			// Now that the image is processed, we can add it to the RSS feed
+	// Generate the responsive sizes, with the height depending on the aspect
+	// ratio, and sizes wider than the image at its own width
+	for _, size := range config.Sizes {
+		resizedWidth := sizeWidth(size, width)
+		resized := transform.Resize(img, resizedWidth, int(float64(resizedWidth)/aspectRatio), transform.Linear)
+		slog.Debug("Image resized", "size", size.Name, "width", resizedWidth)
+		for _, format := range outputFormats() {
+			if err := ctx.Err(); err != nil {
+				return RSSItem{}, err
+			}
+			sizeFile := filepath.Join(outputDir, derivedNameFor(size.Name, imgName, format))
+			if err := saveImage(sizeFile, resized, format.Encoder(config.JPEGQuality)); err != nil {
+				return RSSItem{}, fmt.Errorf("failed to save resized image: %w", err)
+			}
+			slog.Debug("Resized image saved", "sizeFile", sizeFile)
+		}
+	}
 
-			// Get the thumbnail file size
-			thumbFileInfo, err := os.Stat(filepath.Join(outputDir, "thumb_"+imgName))
-			if err != nil {
-				slog.Error("Failed to get thumbnail file info", "error", err)
-				os.Exit(1)
+	// Record the derived images in the manifest, so they aren't regenerated until something changes
+	generated := time.Now()
+	manifest.setImage(file, ManifestImage{
+		Hash:      task.Hash,
+		Settings:  imageSettings(thumbSize),
+		Files:     outputFiles(outputDir, derivedFiles(imgName)),
+		Generated: generated,
+	})
+
+	// Images that aren't part of any feed aren't published, so they aren't registered either
+	if len(task.Feeds) == 0 {
+		return RSSItem{}, nil
+	}
+	item := feedItem(file, task.Hash, outputDir, imgName, metadata, pubDates.batch)
+	item.Feeds = task.Feeds
+	return item, nil
+}
+
+// processCover generates the cover thumbnails of directories. Covers that
+// fail to generate are reported on the errors channel. It returns once the
+// tasks channel is closed, or the context is cancelled.
+func processCover(ctx context.Context, coverTasks <-chan coverTask, errs chan<- error, wg *sync.WaitGroup) {
+	slog.Debug("Starting processCover goroutine")
+	defer wg.Done()
+	for {
+		select {
+		case task, ok := <-coverTasks:
+			if !ok {
+				slog.Debug("Cover tasks channel closed")
+				return
 			}
-			baseURL := config.GalleryURL + filepath.Join(config.GalleryPath, strings.TrimPrefix(outputDir, config.Output))
-			imageURL := baseURL + "/#" + imgName
-			thumbURL := baseURL + "/thumb_" + imgName
-			RSSTasks <- RSSItem{
-				Title:       imgName,
-				Description: "%lt;img src=\"" + thumbURL + "\" alt=\"" + imgName + "\" /&gt;",
-				Link:        imageURL,
-				PubDate:     thumbFileInfo.ModTime().Format(time.RFC1123Z),
-				GUID:        imageURL,
+			slog.Debug("Received cover task", "dir", task.Dir, "source", task.Source)
+			err := generateCover(ctx, task)
+			if err != nil && ctx.Err() == nil {
+				errs <- &fileError{Path: task.Source, Err: err}
 			}
 
-		case <-done:
-			slog.Debug("Received done signal")
+		case <-ctx.Done():
+			slog.Debug("Build cancelled, stopping processCover goroutine")
 			return
 		}
 	}
 }
+
+// generateCover generates the cover thumbnail of a directory: a square crop
+// of the middle of the source image, in the thumbnail size of the directory
+// and the output format. It's recorded in the manifest once written. An
+// original that can't be opened leaves the directory without a cover.
+func generateCover(ctx context.Context, task coverTask) error {
+	outputDir := filepath.Join(config.Output, strings.TrimPrefix(task.Dir, config.Originals))
+
+	img, err := imgio.Open(task.Source)
+	if err != nil {
+		// The original is reported as failed by processImage already, and the
+		// cover is retried on the next build, as it isn't recorded in the manifest
+		slog.Warn("Failed to open cover image, skipping the cover", "dir", task.Dir, "source", task.Source, "error", err)
+		return nil
+	}
+	metadata, err := readMetadata(task.Source)
+	if err != nil {
+		slog.Warn("Failed to read image metadata", "file", task.Source, "error", err)
+	}
+	img = applyOrientation(img, metadata.Orientation)
+
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	cropped := transform.Crop(img, image.Rect(x, y, x+side, y+side))
+	cover := transform.Resize(cropped, task.ThumbSize, task.ThumbSize, transform.Lanczos)
+	slog.Debug("Cover resized", "dir", task.Dir, "thumbSize", task.ThumbSize)
+
+	err = os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name := coverName(task.Source)
+	err = saveImage(filepath.Join(outputDir, name), cover, outputFormat().Encoder(config.JPEGQuality))
+	if err != nil {
+		return fmt.Errorf("failed to save cover image: %w", err)
+	}
+	slog.Debug("Cover saved", "dir", task.Dir, "cover", name)
+
+	manifest.setCover(task.Dir, ManifestImage{
+		Hash:      task.Hash,
+		Settings:  coverSettings(task.Source, task.ThumbSize),
+		Files:     outputFiles(outputDir, []string{name}),
+		Generated: time.Now(),
+	})
+	return nil
+}
+
+// fullDimensions returns the dimensions of the full size image generated from
+// an upright image, depending on the aspect ratio and the config.FullSize,
+// which designates the longest side of the image.
+func fullDimensions(width int, height int) (int, int) {
+	aspectRatio := float64(width) / float64(height)
+	if aspectRatio < 1 {
+		return int(float64(config.FullSize) * aspectRatio), config.FullSize
+	}
+	return config.FullSize, int(float64(config.FullSize) / aspectRatio)
+}
+
+// fullImageSize returns the dimensions of the full size image derived from an
+// original with the given EXIF orientation, from the header of the original,
+// as the full size image may not be generated yet. It returns zeros if the
+// original can't be read.
+func fullImageSize(original string, orientation int) (int, int) {
+	// Copied originals keep the dimensions they're stored with
+	if config.CopyOriginals {
+		return uprightSize(original, 1)
+	}
+	width, height := uprightSize(original, orientation)
+	if width == 0 || height == 0 {
+		return 0, 0
+	}
+	return fullDimensions(width, height)
+}
+
+// uprightSize returns the dimensions of an original after applying the given
+// EXIF orientation, from the header of the original. It returns zeros if the
+// original can't be read.
+func uprightSize(original string, orientation int) (int, int) {
+	_, width, height, err := imageFileInfo(original)
+	if err != nil {
+		slog.Debug("Failed to read original image size", "file", original, "error", err)
+		return 0, 0
+	}
+	// Orientations 5-8 swap width and height
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+	return width, height
+}
+
+// applyOrientation rotates and flips an image according to its EXIF orientation
+// tag (1-8), returning an upright image. Unknown orientations are returned as is.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	slog.Debug("Applying EXIF orientation", "orientation", orientation)
+
+	src := clone.AsRGBA(img)
+	w := src.Bounds().Dx()
+	h := src.Bounds().Dy()
+	minX := src.Bounds().Min.X
+	minY := src.Bounds().Min.Y
+
+	// Orientations 5-8 swap width and height
+	dstWidth, dstHeight := w, h
+	if orientation >= 5 {
+		dstWidth, dstHeight = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := range dstHeight {
+		for x := range dstWidth {
+			var sx, sy int
+			switch orientation {
+			case 2: // flipped horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flipped vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° counter-clockwise
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(minX+sx, minY+sy))
+		}
+	}
+	return dst
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"image"
+	"image/color"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,29 +31,29 @@ func TestProcessImage(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Set up channels and WaitGroup
-	imageTasks := make(chan string)
+	errs := make(chan error, 10)
+	imageTasks := make(chan imageTask)
 	rssTasks := make(chan RSSItem)
-	rssDone := make(chan struct{})
-	done := make(chan struct{})
 	var wg sync.WaitGroup
 	var rssWg sync.WaitGroup
 
 	// Start the processRSSFeed function in a goroutine
 	rssWg.Add(1)
-	go processRSSFeed(rssTasks, &rssWg, rssDone)
+	go processRSSFeed(context.Background(), rssTasks, errs, &rssWg)
 
 	// Start the processImage function in a goroutine
 	wg.Add(1)
-	go processImage(imageTasks, rssTasks, &wg, done)
+	go processImage(context.Background(), imageTasks, rssTasks, errs, &wg)
 
 	// Add the image task to the channel
-	imageTasks <- originalImagePath
-	close(done)
+	imageTasks <- imageTask{Path: originalImagePath}
+	close(imageTasks)
 
 	// Wait for the goroutine to finish
 	wg.Wait()
-	close(rssDone)
+	close(rssTasks)
 	rssWg.Wait()
+	assert.Empty(t, errs)
 
 	// Verify that the output directory was created
 	outputDir := filepath.Join(config.Output, filepath.Dir(strings.TrimPrefix(originalImagePath, config.Originals)))
@@ -90,29 +92,28 @@ func TestProcessImageWithCopyOriginals(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Set up channels and WaitGroup
-	imageTasks := make(chan string, 1)
+	errs := make(chan error, 10)
+	imageTasks := make(chan imageTask, 1)
 	rssTasks := make(chan RSSItem)
-	done := make(chan struct{})
-	rssDone := make(chan struct{})
 	var wg sync.WaitGroup
 	var rssWg sync.WaitGroup
 
 	// Start the processRSSFeed function in a goroutine
 	rssWg.Add(1)
-	go processRSSFeed(rssTasks, &rssWg, rssDone)
+	go processRSSFeed(context.Background(), rssTasks, errs, &rssWg)
 
 	// Start the processImage function in a goroutine
 	wg.Add(1)
-	go processImage(imageTasks, rssTasks, &wg, done)
+	go processImage(context.Background(), imageTasks, rssTasks, errs, &wg)
 
 	// Add the image task to the channel
-	imageTasks <- originalImagePath
+	imageTasks <- imageTask{Path: originalImagePath}
 	time.Sleep(10 * time.Millisecond) // Ensure the task is processed
-	close(done)
+	close(imageTasks)
 
 	// Wait for the goroutine to finish
 	wg.Wait()
-	close(rssDone)
+	close(rssTasks)
 	rssWg.Wait()
 
 	// Verify that the output directory was created
@@ -137,5 +138,134 @@ func setupConfig(tempDir string) {
 	config.ThumbSize = 100
 	config.FullSize = 800
 	config.JPEGQuality = 90
+	config.OutputFormat = "jpeg"
 	config.CopyOriginals = false
 }
+
+func TestProcessImageCorrupt(t *testing.T) {
+	tempDir := t.TempDir()
+	setupConfig(tempDir)
+
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	originalImagePath := filepath.Join(config.Originals, "corrupt.jpg")
+	err = os.WriteFile(originalImagePath, []byte("not a jpeg"), 0644)
+	assert.NoError(t, err)
+
+	imageTasks := make(chan imageTask)
+	rssTasks := make(chan RSSItem, 1)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go processImage(context.Background(), imageTasks, rssTasks, errs, &wg)
+	imageTasks <- imageTask{Path: originalImagePath}
+	close(imageTasks)
+	wg.Wait()
+
+	// The failure is reported instead of exiting, and nothing is added to the RSS feed
+	assert.Len(t, errs, 1)
+	var failure *fileError
+	assert.ErrorAs(t, <-errs, &failure)
+	assert.Equal(t, originalImagePath, failure.Path)
+	assert.Empty(t, rssTasks)
+	_, ok := manifest.image(originalImagePath)
+	assert.False(t, ok)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 2x1 image with a red pixel on the left and a blue pixel on the right
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, red)
+	img.SetRGBA(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		firstPixel  color.RGBA
+	}{
+		{orientation: 0, width: 2, height: 1, firstPixel: red},
+		{orientation: 1, width: 2, height: 1, firstPixel: red},
+		{orientation: 2, width: 2, height: 1, firstPixel: blue},
+		{orientation: 3, width: 2, height: 1, firstPixel: blue},
+		{orientation: 4, width: 2, height: 1, firstPixel: red},
+		{orientation: 5, width: 1, height: 2, firstPixel: red},
+		{orientation: 6, width: 1, height: 2, firstPixel: red},
+		{orientation: 7, width: 1, height: 2, firstPixel: blue},
+		{orientation: 8, width: 1, height: 2, firstPixel: blue},
+	}
+	for _, test := range tests {
+		oriented := applyOrientation(img, test.orientation)
+		assert.Equal(t, test.width, oriented.Bounds().Dx(), "orientation %d", test.orientation)
+		assert.Equal(t, test.height, oriented.Bounds().Dy(), "orientation %d", test.orientation)
+		r, g, b, a := oriented.At(0, 0).RGBA()
+		assert.Equal(t, test.firstPixel, color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}, "orientation %d", test.orientation)
+	}
+}
+
+func TestFullImageSize(t *testing.T) {
+	tempDir := t.TempDir()
+	config.FullSize = 800
+	config.CopyOriginals = false
+	original := filepath.Join(tempDir, "image1.jpg")
+	err := imgio.Save(original, image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	// The longest side is the full size, after applying the orientation
+	width, height := fullImageSize(original, 1)
+	assert.Equal(t, []int{800, 400}, []int{width, height})
+	width, height = fullImageSize(original, 6)
+	assert.Equal(t, []int{400, 800}, []int{width, height})
+
+	// Copied originals keep their size, and unreadable ones have none
+	config.CopyOriginals = true
+	width, height = fullImageSize(original, 6)
+	assert.Equal(t, []int{200, 100}, []int{width, height})
+	config.CopyOriginals = false
+	width, height = fullImageSize(filepath.Join(tempDir, "missing.jpg"), 1)
+	assert.Equal(t, []int{0, 0}, []int{width, height})
+}
+
+func TestProcessImageWithOrientation(t *testing.T) {
+	// Set up temporary directories for testing
+	tempDir := t.TempDir()
+	setupConfig(tempDir)
+
+	// Create the originals directory
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+
+	// Create a landscape image tagged as rotated 90° clockwise
+	originalImagePath := filepath.Join(config.Originals, "portrait.jpg")
+	tiff := buildTIFF([]testTag{{id: tagOrientation, value: uint16(6)}}, nil)
+	writeTestJPEG(t, originalImagePath, 200, 100, exifSegment(tiff))
+
+	// Set up channels and WaitGroup
+	errs := make(chan error, 10)
+	imageTasks := make(chan imageTask)
+	rssTasks := make(chan RSSItem, 1)
+	var wg sync.WaitGroup
+
+	// Start the processImage function in a goroutine
+	wg.Add(1)
+	go processImage(context.Background(), imageTasks, rssTasks, errs, &wg)
+
+	// Add the image task to the channel
+	imageTasks <- imageTask{Path: originalImagePath}
+	close(imageTasks)
+	wg.Wait()
+
+	// Verify that the derived images are portrait
+	thumb, err := imgio.Open(filepath.Join(config.Output, "thumb_portrait.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, thumb.Bounds().Dx())
+	assert.Equal(t, 200, thumb.Bounds().Dy())
+
+	full, err := imgio.Open(filepath.Join(config.Output, "full_portrait.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 400, full.Bounds().Dx())
+	assert.Equal(t, 800, full.Bounds().Dy())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/template"
+)
+
+// configTemplate is the config file written by the init command, listing
+// every setting with its default value.
+var configTemplate = template.Must(template.New("config.yml").Parse(`# Gallery configuration, see the README for details
+name: Photo Gallery
+copyright: ""
+
+# Directories, relative to the working directory
+originals: {{ printf "%q" .Originals }}
+output: {{ printf "%q" .Output }}
+template: {{ printf "%q" .Template }}
+
+# Derived images
+thumbnail_size: 200
+full_size: 2000
+copy_originals: false
+jpeg_quality: 90
+output_format: jpeg
+# Additional formats offered to browsers supporting them: [webp], which is
+# lossless, so larger than JPEG for photos, but smaller for graphics
+output_formats: []
+# Additional widths for responsive images, e.g. [480, 960, 1600]
+sizes: []
+
+# Image order: new, old (modification time), date-new, date-old (date taken),
+# alphabetical, natural (IMG_2 before IMG_10) or manual (listed in order.txt)
+image_order: new
+
+# Publishing
+gallery_path: /
+gallery_url: ""
+rss_feed: false
+# Additional feed formats: rss, atom and json (rss_feed: true is the same as listing rss)
+feeds: []
+# Feed title and description, defaulting to the gallery name, and language
+feed_title: ""
+feed_description: ""
+feed_language: en-us
+# Number of items in a feed, and the age in days of the oldest (0 for no limit)
+feed_max_items: 100
+feed_max_age: 0
+# Group the images published by the same build into one feed item
+feed_digest: false
+feed_digest_title: "%d new images"
+# Feeds of folders: off, folder (its own images) or subtree (including its subfolders)
+folder_feeds: off
+# Date feed items when first published (published), or when taken if known (taken)
+feed_date: published
+# Where first publish dates are kept, defaulting to .gallery-pubdates.json next to the config file
+pubdates_file: ""
+# Generate sitemap.xml listing every page and image, and a robots.txt pointing at it (needs gallery_path: /)
+sitemap: false
+robots_txt: false
+# Generate a page per image, linked from the feed, with OpenGraph tags if gallery_url is set
+image_pages: false
+
+# Remove output files whose originals were deleted or renamed
+prune: true
+# What to do when a file fails to process: skip or fail
+on_error: skip
+`))
+
+// runInit creates a config file, and the originals directory it refers to.
+// With --template, it also exports that embedded template to the templates
+// directory for customization, which is then used instead of the embedded one.
+// Existing files are never overwritten: an existing config file is an error,
+// unless only exporting a template.
+func runInit(ctx context.Context, opts *options) error {
+	values := Config{Originals: "originals", Output: "output", Template: "default"}
+	if opts.Originals != "" {
+		values.Originals = opts.Originals
+	}
+	if opts.Output != "" {
+		values.Output = opts.Output
+	}
+	if opts.Template != "" {
+		values.Template = opts.Template
+		dir, err := exportTemplate(opts.Template)
+		if err != nil {
+			return err
+		}
+		slog.Info("Template exported, customize it there", "template", opts.Template, "dir", dir)
+	}
+
+	f, err := os.OpenFile(opts.Config, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		if opts.Template != "" {
+			slog.Info("Config file already exists, set its template to use the exported template", "config", opts.Config, "template", opts.Template)
+			return nil
+		}
+		return fmt.Errorf("config file %s already exists", opts.Config)
+	}
+	if err != nil {
+		return err
+	}
+	err = configTemplate.Execute(f, values)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Config file created", "config", opts.Config)
+
+	err = os.MkdirAll(values.Originals, 0755)
+	if err != nil {
+		return err
+	}
+	slog.Info("Add your images to the originals directory, and run \"gallery build\"", "originals", values.Originals)
+	return nil
+}
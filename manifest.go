@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestFile is the name of the build manifest in the output directory.
+const manifestFile = ".gallery-manifest.json"
+
+// manifestVersion is bumped whenever the manifest format or the meaning of
+// its fingerprints changes, forcing a full rebuild.
+const manifestVersion = 3
+
+// ManifestImage records the derived images generated from an original.
+type ManifestImage struct {
+	Hash      string    `json:"hash"`
+	Settings  string    `json:"settings"`
+	Files     []string  `json:"files"`
+	Generated time.Time `json:"generated"`
+}
+
+// ManifestPage records the pages generated for a directory: its index page, and its image pages if enabled.
+type ManifestPage struct {
+	Hash  string   `json:"hash"`
+	Files []string `json:"files"`
+}
+
+// Manifest records what was generated in the output directory, and from
+// which content and settings, so a rebuild only regenerates what changed.
+// Images and pages are keyed by their path relative to the originals directory,
+// feeds and sitemaps (the sitemap and robots.txt) by their path relative to
+// the output directory, and assets (the static
+// template files) by their file name, with the hash of the copied template file.
+// Orphaned lists the files no longer expected, but left in place by a dry run.
+type Manifest struct {
+	Version  int                      `json:"version"`
+	Images   map[string]ManifestImage `json:"images"`
+	Pages    map[string]ManifestPage  `json:"pages"`
+	Feeds    map[string]ManifestPage  `json:"feeds"`
+	Covers   map[string]ManifestImage `json:"covers"`
+	Sitemaps map[string]ManifestPage  `json:"sitemaps"`
+	Assets   map[string]string        `json:"assets"`
+	Orphaned []string                 `json:"orphaned,omitempty"`
+	mu       sync.Mutex
+}
+
+// manifest is the manifest of the current build.
+var manifest = newManifest()
+
+// newManifest returns an empty manifest.
+func newManifest() *Manifest {
+	return &Manifest{
+		Version:  manifestVersion,
+		Images:   map[string]ManifestImage{},
+		Pages:    map[string]ManifestPage{},
+		Feeds:    map[string]ManifestPage{},
+		Covers:   map[string]ManifestImage{},
+		Sitemaps: map[string]ManifestPage{},
+		Assets:   map[string]string{},
+	}
+}
+
+// loadManifest loads the manifest from the output directory. A missing,
+// unreadable or outdated manifest results in an empty one, rebuilding everything.
+func loadManifest() *Manifest {
+	path := filepath.Join(config.Output, manifestFile)
+	slog.Debug("Loading manifest", "path", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read manifest, rebuilding everything", "path", path, "error", err)
+		}
+		return newManifest()
+	}
+	m := newManifest()
+	err = json.Unmarshal(data, m)
+	if err != nil || m.Version != manifestVersion {
+		slog.Warn("Ignoring invalid or outdated manifest, rebuilding everything", "path", path, "error", err)
+		return newManifest()
+	}
+	if m.Images == nil {
+		m.Images = map[string]ManifestImage{}
+	}
+	if m.Pages == nil {
+		m.Pages = map[string]ManifestPage{}
+	}
+	if m.Feeds == nil {
+		m.Feeds = map[string]ManifestPage{}
+	}
+	if m.Covers == nil {
+		m.Covers = map[string]ManifestImage{}
+	}
+	if m.Sitemaps == nil {
+		m.Sitemaps = map[string]ManifestPage{}
+	}
+	if m.Assets == nil {
+		m.Assets = map[string]string{}
+	}
+	return m
+}
+
+// save writes the manifest to the output directory.
+func (m *Manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path := filepath.Join(config.Output, manifestFile)
+	slog.Debug("Saving manifest", "path", path)
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// image returns the manifest entry of an original.
+func (m *Manifest) image(original string) (ManifestImage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Images[manifestKey(original)]
+	return entry, ok
+}
+
+// setImage records the derived images generated from an original.
+func (m *Manifest) setImage(original string, entry ManifestImage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Images[manifestKey(original)] = entry
+}
+
+// cover returns the manifest entry of the cover thumbnail of a directory.
+func (m *Manifest) cover(dir string) (ManifestImage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Covers[manifestKey(dir)]
+	return entry, ok
+}
+
+// setCover records the cover thumbnail generated for a directory.
+func (m *Manifest) setCover(dir string, entry ManifestImage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Covers[manifestKey(dir)] = entry
+}
+
+// page returns the manifest entry of a directory.
+func (m *Manifest) page(dir string) (ManifestPage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Pages[manifestKey(dir)]
+	return entry, ok
+}
+
+// setPage records the pages generated for a directory.
+func (m *Manifest) setPage(dir string, entry ManifestPage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Pages[manifestKey(dir)] = entry
+}
+
+// feed returns the manifest entry of a feed.
+func (m *Manifest) feed(file string) (ManifestPage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Feeds[file]
+	return entry, ok
+}
+
+// setFeed records a generated feed.
+func (m *Manifest) setFeed(file string, entry ManifestPage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Feeds[file] = entry
+}
+
+// sitemap returns the manifest entry of the sitemap or robots.txt.
+func (m *Manifest) sitemap(file string) (ManifestPage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Sitemaps[file]
+	return entry, ok
+}
+
+// setSitemap records the generated sitemap or robots.txt.
+func (m *Manifest) setSitemap(file string, entry ManifestPage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sitemaps[file] = entry
+}
+
+// asset returns the hash of the template file an asset was last copied from.
+func (m *Manifest) asset(file string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Assets[file]
+}
+
+// setAsset records the hash of the template file an asset was copied from.
+func (m *Manifest) setAsset(file string, hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Assets[file] = hash
+}
+
+// manifestKey returns the key of an original file or directory, relative to the originals directory.
+func manifestKey(path string) string {
+	rel, err := filepath.Rel(config.Originals, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// hashFile returns the SHA-256 hash of a file's content.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashData returns the SHA-256 hash of data.
+func hashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint returns a hash of the JSON encoding of the given values.
+func fingerprint(values ...any) string {
+	data, err := json.Marshal(values)
+	if err != nil {
+		// Only values that can't be encoded end up here, which is a programming error
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// imageSettings returns the fingerprint of the settings affecting derived
+// images, with the thumbnail size of the folder of the images.
+func imageSettings(thumbSize int) string {
+	return fingerprint(
+		thumbSize,
+		config.FullSize,
+		config.CopyOriginals,
+		config.JPEGQuality,
+		config.OutputFormat,
+		config.OutputFormats,
+		config.Sizes,
+	)
+}
+
+// coverSettings returns the fingerprint of the settings affecting the cover
+// thumbnail of a directory, with the original it's derived from.
+func coverSettings(source string, thumbSize int) string {
+	return fingerprint(
+		manifestKey(source),
+		thumbSize,
+		config.JPEGQuality,
+		config.OutputFormat,
+	)
+}
+
+// pageSettings returns the fingerprint of the settings and the templates affecting index and image pages.
+func pageSettings() string {
+	imageTemplateHash := ""
+	if config.ImagePages {
+		imageTemplateHash = templateFileHash("image.go.html")
+	}
+	return fingerprint(
+		config.Template,
+		templateFileHash("index.go.html"),
+		config.ImagePages,
+		imageTemplateHash,
+		config.GalleryURL,
+		feedLinks(Dir{}),
+		config.Name,
+		config.Copyright,
+		config.GalleryPath,
+		config.ImageOrder,
+		config.CopyOriginals,
+		config.FullSize,
+		config.OutputFormat,
+		config.OutputFormats,
+		config.Sizes,
+	)
+}
+
+// feedSettings returns the fingerprint of the settings and the template affecting a feed.
+// The feed settings themselves (title, links, copyright...) are part of the feed content.
+func feedSettings(format feedFormat) string {
+	templateHash := ""
+	if format.Template != "" {
+		templateHash = templateFileHash(format.Template)
+	}
+	return fingerprint(
+		config.Template,
+		templateHash,
+	)
+}
+
+// filesExist reports whether all the given files exist in the output directory.
+func filesExist(files []string) bool {
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(config.Output, file)); err != nil {
+			slog.Debug("Output file is missing", "file", file, "error", err)
+			return false
+		}
+	}
+	return true
+}
+
+// pageHash returns the fingerprint of everything affecting the index page of a
+// directory: its images and their content, its subdirectories, its folder
+// config, its cover and the original it's derived from, and the settings.
+func pageHash(dir Dir) string {
+	files := []string{}
+	for path, file := range dir.Files {
+		files = append(files, manifestKey(path)+" "+file.Hash+" "+file.ModTime.UTC().Format(time.RFC3339Nano))
+	}
+	sort.Strings(files)
+	subDirs := []string{}
+	for path, subDir := range dir.SubDirs {
+		subDirs = append(subDirs, fingerprint(manifestKey(path), subDir))
+	}
+	sort.Strings(subDirs)
+	coverSource := ""
+	if dir.CoverSource != "" {
+		coverSource = manifestKey(dir.CoverSource)
+	}
+	return fingerprint(files, subDirs, dir.Folder, dir.Cover, coverSource, pageSettings())
+}
+
+// outputFiles returns the paths of the given files in an output directory, relative to the output directory.
+func outputFiles(outputDir string, names []string) []string {
+	files := []string{}
+	for _, name := range names {
+		rel, err := filepath.Rel(config.Output, filepath.Join(outputDir, name))
+		if err != nil {
+			rel = filepath.Join(outputDir, name)
+		}
+		files = append(files, filepath.ToSlash(rel))
+	}
+	return files
+}
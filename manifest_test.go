@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestSaveAndLoad(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = filepath.Join(tempDir, "output")
+	config.Originals = filepath.Join(tempDir, "originals")
+	err := os.MkdirAll(config.Output, 0755)
+	assert.NoError(t, err)
+
+	// A missing manifest results in an empty one
+	m := loadManifest()
+	assert.Empty(t, m.Images)
+	assert.Empty(t, m.Pages)
+
+	// Record an image and a page, keyed relative to the originals directory
+	generated := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	m.setImage(filepath.Join(config.Originals, "album", "image1.jpg"), ManifestImage{
+		Hash:      "abc",
+		Settings:  imageSettings(config.ThumbSize),
+		Files:     []string{"album/thumb_image1.jpg", "album/full_image1.jpg"},
+		Generated: generated,
+	})
+	m.setPage(filepath.Join(config.Originals, "album"), ManifestPage{Hash: "def", Files: []string{"album/index.html"}})
+	err = m.save()
+	assert.NoError(t, err)
+
+	// Load the manifest again and verify the entries
+	m = loadManifest()
+	entry, ok := m.image(filepath.Join(config.Originals, "album", "image1.jpg"))
+	assert.True(t, ok)
+	assert.Equal(t, "abc", entry.Hash)
+	assert.Equal(t, []string{"album/thumb_image1.jpg", "album/full_image1.jpg"}, entry.Files)
+	assert.True(t, generated.Equal(entry.Generated))
+	assert.Contains(t, m.Images, "album/image1.jpg")
+	page, ok := m.page(filepath.Join(config.Originals, "album"))
+	assert.True(t, ok)
+	assert.Equal(t, "def", page.Hash)
+}
+
+func TestLoadManifest_Invalid(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = tempDir
+
+	// Invalid JSON and outdated versions are ignored
+	for _, content := range []string{"not json", `{"version": 0, "images": {"a.jpg": {"hash": "abc"}}}`} {
+		err := os.WriteFile(filepath.Join(tempDir, manifestFile), []byte(content), 0644)
+		assert.NoError(t, err)
+		m := loadManifest()
+		assert.Empty(t, m.Images)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	assert.Equal(t, fingerprint("a", 1), fingerprint("a", 1))
+	assert.NotEqual(t, fingerprint("a", 1), fingerprint("a", 2))
+	assert.NotEqual(t, fingerprint("ab", "c"), fingerprint("a", "bc"))
+
+	config.JPEGQuality = 90
+	settings := imageSettings(config.ThumbSize)
+	config.JPEGQuality = 80
+	assert.NotEqual(t, settings, imageSettings(config.ThumbSize))
+	config.JPEGQuality = 90
+}
+
+func TestHashFile(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "file.txt")
+	err := os.WriteFile(path, []byte("test content"), 0644)
+	assert.NoError(t, err)
+
+	hash, err := hashFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "6ae8a75555209fd6c44157c0aed8016e763ff435a19cf186f76863140143ff72", hash)
+
+	_, err = hashFile(filepath.Join(tempDir, "missing.txt"))
+	assert.Error(t, err)
+}
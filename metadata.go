@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXIF tags we extract from IFD0 and the EXIF sub-IFD.
+const (
+	tagMake              = 0x010F
+	tagModel             = 0x0110
+	tagOrientation       = 0x0112
+	tagDateTime          = 0x0132
+	tagExifIFD           = 0x8769
+	tagExposureTime      = 0x829A
+	tagFNumber           = 0x829D
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagOffsetTimeOrig    = 0x9011
+	tagFocalLength       = 0x920A
+	tagFocalLengthIn35mm = 0xA405
+	tagLensModel         = 0xA434
+)
+
+// exifDateLayout is the layout EXIF uses for its date/time fields.
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// Headers identifying the APPn segments we read metadata from.
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+// IPTC IIM datasets we extract from record 2 (application record).
+const (
+	iptcObjectName      = 5
+	iptcKeywords        = 25
+	iptcCaptionAbstract = 120
+)
+
+var errNoEXIF = errors.New("no EXIF data found")
+
+// readMetadata reads the EXIF, IPTC and XMP metadata from an image file.
+// Files without metadata, or in a format we don't parse, return an empty Metadata.
+func readMetadata(path string) (Metadata, error) {
+	slog.Debug("Reading metadata", "path", path)
+	f, err := os.Open(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer f.Close()
+
+	segments, err := readJPEGSegments(bufio.NewReader(f))
+	if err != nil {
+		slog.Debug("No JPEG metadata segments found", "path", path, "error", err)
+		return Metadata{}, nil
+	}
+
+	meta := Metadata{}
+	xmp := xmpData{}
+	for _, segment := range segments {
+		switch {
+		case segment.marker == 0xE1 && bytes.HasPrefix(segment.data, exifHeader):
+			err := meta.parseEXIF(segment.data[len(exifHeader):])
+			if err != nil {
+				return Metadata{}, fmt.Errorf("failed to parse EXIF in %s: %w", path, err)
+			}
+		case segment.marker == 0xE1 && bytes.HasPrefix(segment.data, xmpHeader):
+			err := xmp.parse(segment.data[len(xmpHeader):])
+			if err != nil {
+				slog.Warn("Failed to parse XMP metadata", "path", path, "error", err)
+			}
+		case segment.marker == 0xED && bytes.HasPrefix(segment.data, photoshopHeader):
+			err := meta.parseIPTC(segment.data[len(photoshopHeader):])
+			if err != nil {
+				slog.Warn("Failed to parse IPTC metadata", "path", path, "error", err)
+			}
+		}
+	}
+	meta.mergeXMP(xmp)
+	slog.Debug("Metadata read", "path", path, "exif", meta.EXIF, "iptc", meta.IPTC)
+	return meta, nil
+}
+
+// description returns the text describing the image, preferring the caption
+// over the title, and falling back to the given file name.
+func (m Metadata) description(fallback string) string {
+	if m.Caption != "" {
+		return m.Caption
+	}
+	if m.Title != "" {
+		return m.Title
+	}
+	return fallback
+}
+
+// title returns the short title of the image, preferring the title over the
+// caption, and falling back to the given file name.
+func (m Metadata) title(fallback string) string {
+	if m.Title != "" {
+		return m.Title
+	}
+	if m.Caption != "" {
+		return m.Caption
+	}
+	return fallback
+}
+
+// jpegSegment is an application segment from a JPEG file header.
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// readJPEGSegments reads the APPn segments of a JPEG file, stopping at the
+// start of the image data.
+func readJPEGSegments(r io.Reader) ([]jpegSegment, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	segments := []jpegSegment{}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:2]); err != nil {
+			return segments, err
+		}
+		if header[0] != 0xFF {
+			return segments, errors.New("invalid JPEG marker")
+		}
+		marker := header[1]
+		// Markers without a payload
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+		// Start of scan or end of image, no more metadata
+		if marker == 0xDA || marker == 0xD9 {
+			return segments, nil
+		}
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			return segments, err
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 {
+			return segments, errors.New("invalid JPEG segment length")
+		}
+		data := make([]byte, length-2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return segments, err
+		}
+		if marker >= 0xE0 && marker <= 0xEF {
+			segments = append(segments, jpegSegment{marker: marker, data: data})
+		}
+	}
+}
+
+// tiffEntry is a single IFD entry with its raw value bytes.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffReader reads IFDs from TIFF-structured data, as used by EXIF.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// newTIFFReader validates the TIFF header and returns a reader and the offset of IFD0.
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, errNoEXIF
+	}
+	r := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("invalid TIFF byte order")
+	}
+	if r.order.Uint16(data[2:]) != 42 {
+		return nil, 0, errors.New("invalid TIFF header")
+	}
+	return r, r.order.Uint32(data[4:]), nil
+}
+
+// tiffTypeSizes maps TIFF field types to the size in bytes of a single value.
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// ifd reads the IFD at the given offset.
+func (r *tiffReader) ifd(offset uint32) (map[uint16]tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return nil, errors.New("IFD offset out of range")
+	}
+	count := uint32(r.order.Uint16(r.data[offset:]))
+	if uint64(offset)+2+uint64(count)*12 > uint64(len(r.data)) {
+		return nil, errors.New("IFD entries out of range")
+	}
+	entries := make(map[uint16]tiffEntry, count)
+	for i := range count {
+		pos := offset + 2 + i*12
+		tag := r.order.Uint16(r.data[pos:])
+		typ := r.order.Uint16(r.data[pos+2:])
+		n := r.order.Uint32(r.data[pos+4:])
+		size, ok := tiffTypeSizes[typ]
+		if !ok {
+			continue
+		}
+		length := uint64(size) * uint64(n)
+		start := uint64(pos + 8)
+		if length > 4 {
+			start = uint64(r.order.Uint32(r.data[pos+8:]))
+		}
+		if start+length > uint64(len(r.data)) {
+			slog.Debug("Skipping EXIF entry with out of range value", "tag", tag)
+			continue
+		}
+		entries[tag] = tiffEntry{typ: typ, count: n, value: r.data[start : start+length]}
+	}
+	return entries, nil
+}
+
+// ascii returns the value of an ASCII entry.
+func (r *tiffReader) ascii(e tiffEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// uint returns the first value of a BYTE, SHORT or LONG entry.
+func (r *tiffReader) uint(e tiffEntry) (uint32, bool) {
+	switch {
+	case e.typ == 1 && len(e.value) >= 1:
+		return uint32(e.value[0]), true
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(r.order.Uint16(e.value)), true
+	case e.typ == 4 && len(e.value) >= 4:
+		return r.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+// rational returns the first value of a RATIONAL or SRATIONAL entry as a float.
+func (r *tiffReader) rational(e tiffEntry) (float64, bool) {
+	if (e.typ != 5 && e.typ != 10) || len(e.value) < 8 {
+		return 0, false
+	}
+	num := r.order.Uint32(e.value)
+	den := r.order.Uint32(e.value[4:])
+	if den == 0 {
+		return 0, false
+	}
+	if e.typ == 10 {
+		return float64(int32(num)) / float64(int32(den)), true
+	}
+	return float64(num) / float64(den), true
+}
+
+// parseEXIF parses a TIFF-structured EXIF block and fills in the EXIF fields.
+func (m *Metadata) parseEXIF(data []byte) error {
+	r, offset, err := newTIFFReader(data)
+	if err != nil {
+		return err
+	}
+	ifd0, err := r.ifd(offset)
+	if err != nil {
+		return err
+	}
+	exif := map[uint16]tiffEntry{}
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if exifOffset, ok := r.uint(e); ok {
+			exif, err = r.ifd(exifOffset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cameraMake := ""
+	if e, ok := ifd0[tagMake]; ok {
+		cameraMake = r.ascii(e)
+	}
+	if e, ok := ifd0[tagModel]; ok {
+		model := r.ascii(e)
+		// Most cameras repeat the make in the model name
+		if cameraMake != "" && !strings.HasPrefix(strings.ToLower(model), strings.ToLower(cameraMake)) {
+			model = cameraMake + " " + model
+		}
+		m.Camera = model
+	} else {
+		m.Camera = cameraMake
+	}
+	if e, ok := ifd0[tagOrientation]; ok {
+		if v, ok := r.uint(e); ok {
+			m.Orientation = int(v)
+		}
+	}
+	if e, ok := exif[tagLensModel]; ok {
+		m.Lens = r.ascii(e)
+	}
+	if e, ok := exif[tagFocalLength]; ok {
+		if v, ok := r.rational(e); ok {
+			m.FocalLength = formatFloat(v) + " mm"
+		}
+		if e, ok := exif[tagFocalLengthIn35mm]; ok {
+			if v, ok := r.uint(e); ok && v > 0 && m.FocalLength != strconv.Itoa(int(v))+" mm" {
+				m.FocalLength += " (" + strconv.Itoa(int(v)) + " mm eq.)"
+			}
+		}
+	}
+	if e, ok := exif[tagFNumber]; ok {
+		if v, ok := r.rational(e); ok && v > 0 {
+			m.Aperture = "f/" + formatFloat(v)
+		}
+	}
+	if e, ok := exif[tagExposureTime]; ok {
+		if v, ok := r.rational(e); ok && v > 0 {
+			if v < 1 {
+				m.ShutterSpeed = "1/" + strconv.Itoa(int(math.Round(1/v))) + " s"
+			} else {
+				m.ShutterSpeed = formatFloat(v) + " s"
+			}
+		}
+	}
+	if e, ok := exif[tagISO]; ok {
+		if v, ok := r.uint(e); ok {
+			m.ISO = strconv.Itoa(int(v))
+		}
+	}
+
+	// Prefer the original capture time, falling back to the file modification time in IFD0
+	dateEntry, ok := exif[tagDateTimeOriginal]
+	if !ok {
+		dateEntry, ok = ifd0[tagDateTime]
+	}
+	if ok {
+		location := time.Local
+		if e, ok := exif[tagOffsetTimeOrig]; ok {
+			if offset, err := time.Parse("-07:00", r.ascii(e)); err == nil {
+				location = offset.Location()
+			}
+		}
+		if t, err := time.ParseInLocation(exifDateLayout, r.ascii(dateEntry), location); err == nil {
+			m.DateTime = t
+		} else {
+			slog.Debug("Failed to parse EXIF date", "date", r.ascii(dateEntry), "error", err)
+		}
+	}
+
+	m.EXIF = [][]string{}
+	for _, field := range [][]string{
+		{"Camera", m.Camera},
+		{"Lens", m.Lens},
+		{"Focal length", m.FocalLength},
+		{"Aperture", m.Aperture},
+		{"Shutter speed", m.ShutterSpeed},
+		{"ISO", m.ISO},
+	} {
+		if field[1] != "" {
+			m.EXIF = append(m.EXIF, field)
+		}
+	}
+	if !m.DateTime.IsZero() {
+		m.EXIF = append(m.EXIF, []string{"Date", m.DateTime.Format("2006-01-02 15:04")})
+	}
+	return nil
+}
+
+// formatFloat formats a float with at most one decimal, dropping a trailing ".0".
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+// parseIPTC parses the Photoshop image resource blocks of an APP13 segment,
+// and fills in the title, caption and keywords from the IPTC IIM resource.
+func (m *Metadata) parseIPTC(data []byte) error {
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(data[4:])
+		// The resource name is a Pascal string, padded to an even length
+		nameLength := int(data[6]) + 1
+		nameLength += nameLength % 2
+		if 6+nameLength+4 > len(data) {
+			return errors.New("image resource block out of range")
+		}
+		size := int(binary.BigEndian.Uint32(data[6+nameLength:]))
+		start := 6 + nameLength + 4
+		if start+size > len(data) {
+			return errors.New("image resource data out of range")
+		}
+		if id == 0x0404 {
+			m.parseIIM(data[start : start+size])
+		}
+		// Resource data is also padded to an even length
+		data = data[min(start+size+size%2, len(data)):]
+	}
+	return nil
+}
+
+// parseIIM parses IPTC IIM datasets, ignoring anything outside the application record.
+func (m *Metadata) parseIIM(data []byte) {
+	for len(data) >= 5 && data[0] == 0x1C {
+		record := data[1]
+		dataset := data[2]
+		size := int(binary.BigEndian.Uint16(data[3:]))
+		// Extended datasets (size with the high bit set) are not used for text fields
+		if size&0x8000 != 0 || 5+size > len(data) {
+			return
+		}
+		value := strings.TrimSpace(string(data[5 : 5+size]))
+		data = data[5+size:]
+		if record != 2 || value == "" {
+			continue
+		}
+		switch dataset {
+		case iptcObjectName:
+			m.Title = value
+		case iptcCaptionAbstract:
+			m.Caption = value
+		case iptcKeywords:
+			m.Keywords = append(m.Keywords, value)
+		}
+	}
+}
+
+// iptcFields returns the IPTC label/value pairs for display.
+func (m *Metadata) iptcFields() [][]string {
+	fields := [][]string{}
+	if m.Title != "" {
+		fields = append(fields, []string{"Title", m.Title})
+	}
+	if m.Caption != "" {
+		fields = append(fields, []string{"Caption", m.Caption})
+	}
+	if len(m.Keywords) > 0 {
+		fields = append(fields, []string{"Keywords", strings.Join(m.Keywords, ", ")})
+	}
+	return fields
+}
+
+// xmpData holds the Dublin Core fields we read from an XMP packet.
+type xmpData struct {
+	Title       string
+	Description string
+	Subject     []string
+}
+
+// dublinCoreNS is the XML namespace of the Dublin Core elements used by XMP.
+const dublinCoreNS = "http://purl.org/dc/elements/1.1/"
+
+// parse reads dc:title, dc:description and dc:subject from an XMP packet.
+func (x *xmpData) parse(data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	field := ""
+	inItem := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space == dublinCoreNS {
+				field = t.Name.Local
+			} else if field != "" && t.Name.Local == "li" {
+				inItem = true
+			}
+		case xml.EndElement:
+			if t.Name.Space == dublinCoreNS {
+				field = ""
+			} else if t.Name.Local == "li" {
+				inItem = false
+			}
+		case xml.CharData:
+			value := strings.TrimSpace(string(t))
+			if !inItem || value == "" {
+				continue
+			}
+			switch field {
+			case "title":
+				if x.Title == "" {
+					x.Title = value
+				}
+			case "description":
+				if x.Description == "" {
+					x.Description = value
+				}
+			case "subject":
+				x.Subject = append(x.Subject, value)
+			}
+		}
+	}
+}
+
+// mergeXMP fills in the fields IPTC left empty with the values from XMP.
+func (m *Metadata) mergeXMP(x xmpData) {
+	if m.Title == "" {
+		m.Title = x.Title
+	}
+	if m.Caption == "" {
+		m.Caption = x.Description
+	}
+	if len(m.Keywords) == 0 {
+		m.Keywords = x.Subject
+	}
+	m.IPTC = m.iptcFields()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testTag is an IFD entry used to build EXIF test data.
+// The value is a string (ASCII), a uint16 (SHORT), or a [2]uint32 (RATIONAL).
+type testTag struct {
+	id    uint16
+	value any
+}
+
+// buildTIFF builds a little-endian TIFF structure with IFD0 and an optional EXIF sub-IFD.
+func buildTIFF(ifd0 []testTag, exif []testTag) []byte {
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, testTag{id: tagExifIFD, value: uint32(0)})
+	}
+	ifdSize := func(tags []testTag) int { return 2 + 12*len(tags) + 4 }
+	exifOffset := 8 + ifdSize(ifd0)
+	dataOffset := exifOffset
+	if len(exif) > 0 {
+		dataOffset += ifdSize(exif)
+	}
+
+	order := binary.LittleEndian
+	ifds := &bytes.Buffer{}
+	data := &bytes.Buffer{}
+	writeIFD := func(tags []testTag) {
+		binary.Write(ifds, order, uint16(len(tags)))
+		for _, tag := range tags {
+			entry := make([]byte, 12)
+			order.PutUint16(entry, tag.id)
+			switch v := tag.value.(type) {
+			case string:
+				b := append([]byte(v), 0)
+				order.PutUint16(entry[2:], 2)
+				order.PutUint32(entry[4:], uint32(len(b)))
+				if len(b) <= 4 {
+					copy(entry[8:], b)
+				} else {
+					order.PutUint32(entry[8:], uint32(dataOffset+data.Len()))
+					data.Write(b)
+				}
+			case uint16:
+				order.PutUint16(entry[2:], 3)
+				order.PutUint32(entry[4:], 1)
+				order.PutUint16(entry[8:], v)
+			case uint32:
+				order.PutUint16(entry[2:], 4)
+				order.PutUint32(entry[4:], 1)
+				order.PutUint32(entry[8:], uint32(exifOffset))
+			case [2]uint32:
+				order.PutUint16(entry[2:], 5)
+				order.PutUint32(entry[4:], 1)
+				order.PutUint32(entry[8:], uint32(dataOffset+data.Len()))
+				binary.Write(data, order, v)
+			}
+			ifds.Write(entry)
+		}
+		binary.Write(ifds, order, uint32(0))
+	}
+	writeIFD(ifd0)
+	if len(exif) > 0 {
+		writeIFD(exif)
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("II")
+	binary.Write(out, order, uint16(42))
+	binary.Write(out, order, uint32(8))
+	out.Write(ifds.Bytes())
+	out.Write(data.Bytes())
+	return out.Bytes()
+}
+
+// writeTestJPEG writes a JPEG of the given size to path, inserting the given
+// APPn segments right after the SOI marker.
+func writeTestJPEG(t *testing.T, path string, width, height int, segments ...jpegSegment) {
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height)), &jpeg.Options{Quality: 90})
+	assert.NoError(t, err)
+	encoded := buf.Bytes()
+
+	out := &bytes.Buffer{}
+	out.Write(encoded[:2])
+	for _, segment := range segments {
+		out.Write([]byte{0xFF, segment.marker})
+		binary.Write(out, binary.BigEndian, uint16(len(segment.data)+2))
+		out.Write(segment.data)
+	}
+	out.Write(encoded[2:])
+	err = os.WriteFile(path, out.Bytes(), 0644)
+	assert.NoError(t, err)
+}
+
+// exifSegment wraps a TIFF structure in an APP1 EXIF segment.
+func exifSegment(tiff []byte) jpegSegment {
+	return jpegSegment{marker: 0xE1, data: append([]byte("Exif\x00\x00"), tiff...)}
+}
+
+func TestReadMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+	imagePath := filepath.Join(tempDir, "exif.jpg")
+
+	tiff := buildTIFF(
+		[]testTag{
+			{id: tagMake, value: "Canon"},
+			{id: tagModel, value: "Canon EOS R5"},
+			{id: tagOrientation, value: uint16(6)},
+		},
+		[]testTag{
+			{id: tagExposureTime, value: [2]uint32{1, 250}},
+			{id: tagFNumber, value: [2]uint32{18, 10}},
+			{id: tagISO, value: uint16(400)},
+			{id: tagDateTimeOriginal, value: "2025:06:01 14:30:00"},
+			{id: tagFocalLength, value: [2]uint32{50, 1}},
+			{id: tagLensModel, value: "RF50mm F1.8 STM"},
+		},
+	)
+	writeTestJPEG(t, imagePath, 20, 10, exifSegment(tiff))
+
+	meta, err := readMetadata(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "Canon EOS R5", meta.Camera)
+	assert.Equal(t, "RF50mm F1.8 STM", meta.Lens)
+	assert.Equal(t, "50 mm", meta.FocalLength)
+	assert.Equal(t, "f/1.8", meta.Aperture)
+	assert.Equal(t, "1/250 s", meta.ShutterSpeed)
+	assert.Equal(t, "400", meta.ISO)
+	assert.Equal(t, 6, meta.Orientation)
+	assert.Equal(t, time.Date(2025, 6, 1, 14, 30, 0, 0, time.Local), meta.DateTime)
+	assert.Equal(t, []string{"Camera", "Canon EOS R5"}, meta.EXIF[0])
+	assert.Equal(t, []string{"Date", "2025-06-01 14:30"}, meta.EXIF[len(meta.EXIF)-1])
+}
+
+func TestReadMetadata_MakeNotInModel(t *testing.T) {
+	tempDir := t.TempDir()
+	imagePath := filepath.Join(tempDir, "exif.jpg")
+
+	tiff := buildTIFF([]testTag{
+		{id: tagMake, value: "NIKON CORPORATION"},
+		{id: tagModel, value: "Z 6"},
+	}, nil)
+	writeTestJPEG(t, imagePath, 20, 10, exifSegment(tiff))
+
+	meta, err := readMetadata(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "NIKON CORPORATION Z 6", meta.Camera)
+	assert.Equal(t, [][]string{{"Camera", "NIKON CORPORATION Z 6"}}, meta.EXIF)
+}
+
+func TestReadMetadata_NoEXIF(t *testing.T) {
+	tempDir := t.TempDir()
+	imagePath := filepath.Join(tempDir, "plain.jpg")
+	writeTestJPEG(t, imagePath, 20, 10)
+
+	meta, err := readMetadata(imagePath)
+	assert.NoError(t, err)
+	assert.Empty(t, meta.EXIF)
+	assert.Equal(t, 0, meta.Orientation)
+}
+
+func TestReadMetadata_InvalidEXIF(t *testing.T) {
+	tempDir := t.TempDir()
+	imagePath := filepath.Join(tempDir, "broken.jpg")
+	writeTestJPEG(t, imagePath, 20, 10, exifSegment([]byte("XX*\x00\x08\x00\x00\x00")))
+
+	_, err := readMetadata(imagePath)
+	assert.Error(t, err)
+}
+
+// iptcSegment builds an APP13 segment with an IPTC IIM resource block
+// holding the given record 2 datasets.
+func iptcSegment(datasets map[byte][]string) jpegSegment {
+	iim := &bytes.Buffer{}
+	for _, dataset := range []byte{iptcObjectName, iptcKeywords, iptcCaptionAbstract} {
+		for _, value := range datasets[dataset] {
+			iim.Write([]byte{0x1C, 2, dataset})
+			binary.Write(iim, binary.BigEndian, uint16(len(value)))
+			iim.WriteString(value)
+		}
+	}
+	resource := &bytes.Buffer{}
+	resource.WriteString("8BIM")
+	binary.Write(resource, binary.BigEndian, uint16(0x0404))
+	resource.Write([]byte{0, 0})
+	binary.Write(resource, binary.BigEndian, uint32(iim.Len()))
+	resource.Write(iim.Bytes())
+	if iim.Len()%2 == 1 {
+		resource.WriteByte(0)
+	}
+	return jpegSegment{marker: 0xED, data: append([]byte("Photoshop 3.0\x00"), resource.Bytes()...)}
+}
+
+// xmpSegment builds an APP1 XMP segment with the given Dublin Core fields.
+func xmpSegment(title, description string, subjects ...string) jpegSegment {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">` + title + `</rdf:li></rdf:Alt></dc:title>
+<dc:description><rdf:Alt><rdf:li xml:lang="x-default">` + description + `</rdf:li></rdf:Alt></dc:description>
+<dc:subject><rdf:Bag>`
+	for _, subject := range subjects {
+		packet += `<rdf:li>` + subject + `</rdf:li>`
+	}
+	packet += `</rdf:Bag></dc:subject></rdf:Description></rdf:RDF></x:xmpmeta>`
+	return jpegSegment{marker: 0xE1, data: append([]byte("http://ns.adobe.com/xap/1.0/\x00"), packet...)}
+}
+
+func TestReadMetadata_IPTC(t *testing.T) {
+	tempDir := t.TempDir()
+	imagePath := filepath.Join(tempDir, "iptc.jpg")
+
+	writeTestJPEG(t, imagePath, 20, 10, iptcSegment(map[byte][]string{
+		iptcObjectName:      {"Sunset"},
+		iptcCaptionAbstract: {"Sunset over the harbour"},
+		iptcKeywords:        {"sea", "evening"},
+	}))
+
+	meta, err := readMetadata(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sunset", meta.Title)
+	assert.Equal(t, "Sunset over the harbour", meta.Caption)
+	assert.Equal(t, []string{"sea", "evening"}, meta.Keywords)
+	assert.Equal(t, [][]string{
+		{"Title", "Sunset"},
+		{"Caption", "Sunset over the harbour"},
+		{"Keywords", "sea, evening"},
+	}, meta.IPTC)
+}
+
+func TestReadMetadata_XMP(t *testing.T) {
+	tempDir := t.TempDir()
+	imagePath := filepath.Join(tempDir, "xmp.jpg")
+
+	// IPTC takes precedence, XMP fills in the missing fields
+	writeTestJPEG(t, imagePath, 20, 10,
+		xmpSegment("XMP title", "XMP caption", "beach", "summer"),
+		iptcSegment(map[byte][]string{iptcObjectName: {"IPTC title"}}),
+	)
+
+	meta, err := readMetadata(imagePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "IPTC title", meta.Title)
+	assert.Equal(t, "XMP caption", meta.Caption)
+	assert.Equal(t, []string{"beach", "summer"}, meta.Keywords)
+}
+
+func TestMetadataDescriptionAndTitle(t *testing.T) {
+	assert.Equal(t, "image.jpg", Metadata{}.description("image.jpg"))
+	assert.Equal(t, "image.jpg", Metadata{}.title("image.jpg"))
+	assert.Equal(t, "Title", Metadata{Title: "Title"}.description("image.jpg"))
+	assert.Equal(t, "Caption", Metadata{Title: "Title", Caption: "Caption"}.description("image.jpg"))
+	assert.Equal(t, "Title", Metadata{Title: "Title", Caption: "Caption"}.title("image.jpg"))
+	assert.Equal(t, "Caption", Metadata{Caption: "Caption"}.title("image.jpg"))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// imageOrders lists the orders images and folders can be sorted in:
+//   - new and old: by modification time, newest or oldest first
+//   - date-new and date-old: by the date the image was taken, from the EXIF
+//     DateTimeOriginal, falling back to the modification time
+//   - alphabetical: by name, ignoring case
+//   - natural: by name, ignoring case, with numbers compared by value, so IMG_2 comes before IMG_10
+//   - manual: as listed in the order file of the folder, followed by the unlisted ones in natural order
+var imageOrders = []string{"new", "old", "date-new", "date-old", "alphabetical", "natural", "manual"}
+
+// orderFile is the name of the file listing the images and subfolders of a folder in manual order.
+const orderFile = "order.txt"
+
+// validImageOrder reports whether an image order is one of the imageOrders.
+func validImageOrder(order string) bool {
+	return slices.Contains(imageOrders, order)
+}
+
+// readOrderFile reads the order file of a folder: one file or folder name
+// per line, ignoring blank lines and lines starting with #. A missing order
+// file is no error, and results in no names.
+func readOrderFile(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, orderFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
+
+// sortFiles sorts the images of a folder in the given order. Images that
+// compare equal are sorted by name, so the order is deterministic.
+func sortFiles(files []File, order string, manual []string) {
+	positions := manualPositions(manual)
+	slices.SortFunc(files, func(a, b File) int {
+		var c int
+		switch order {
+		case "new":
+			c = b.ModTime.Compare(a.ModTime)
+		case "old":
+			c = a.ModTime.Compare(b.ModTime)
+		case "date-new":
+			c = b.date().Compare(a.date())
+		case "date-old":
+			c = a.date().Compare(b.date())
+		case "alphabetical":
+			c = cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		case "manual":
+			c = compareManual(a.Name, b.Name, positions)
+		}
+		if c != 0 {
+			return c
+		}
+		return compareNames(a.Name, b.Name)
+	})
+}
+
+// sortSubDirs sorts the subfolders of a folder by their sort position, and
+// then in the given order, with the date orders comparing the dates of their
+// newest images. Subfolders that compare equal are sorted by name.
+func sortSubDirs(subDirs []SubDir, order string, manual []string) {
+	positions := manualPositions(manual)
+	slices.SortFunc(subDirs, func(a, b SubDir) int {
+		if c := cmp.Compare(a.Sort, b.Sort); c != 0 {
+			return c
+		}
+		var c int
+		switch order {
+		case "new", "date-new":
+			c = b.Latest.Compare(a.Latest)
+		case "old", "date-old":
+			c = a.Latest.Compare(b.Latest)
+		case "alphabetical":
+			c = cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		case "manual":
+			c = compareManual(a.Name, b.Name, positions)
+		}
+		if c != 0 {
+			return c
+		}
+		return compareNames(a.Name, b.Name)
+	})
+}
+
+// manualPositions returns the position of each name in a manual order.
+func manualPositions(manual []string) map[string]int {
+	positions := map[string]int{}
+	for i, name := range manual {
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+	return positions
+}
+
+// compareManual compares names by their position in a manual order, with
+// the unlisted names after the listed ones.
+func compareManual(a, b string, positions map[string]int) int {
+	posA, okA := positions[a]
+	posB, okB := positions[b]
+	switch {
+	case okA && okB:
+		return cmp.Compare(posA, posB)
+	case okA:
+		return -1
+	case okB:
+		return 1
+	}
+	return 0
+}
+
+// compareNames compares names in natural order, falling back to comparing
+// them as is, so only identical names are equal.
+func compareNames(a, b string) int {
+	if c := compareNatural(a, b); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// compareNatural compares names ignoring case, with runs of digits compared
+// by their numeric value, e.g. "IMG_2.jpg" before "img_10.jpg".
+func compareNatural(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			numA, restA := splitDigits(a)
+			numB, restB := splitDigits(b)
+			// Compare the numbers without leading zeros by length first, so
+			// numbers of any length compare correctly without parsing them
+			trimmedA, trimmedB := strings.TrimLeft(numA, "0"), strings.TrimLeft(numB, "0")
+			if c := cmp.Compare(len(trimmedA), len(trimmedB)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(trimmedA, trimmedB); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(len(numA), len(numB)); c != 0 {
+				return c
+			}
+			a, b = restA, restB
+			continue
+		}
+		if c := cmp.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// isDigit reports whether a byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// splitDigits splits a string into its leading digits and the rest.
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareNatural(t *testing.T) {
+	assert.Negative(t, compareNatural("IMG_2.jpg", "IMG_10.jpg"))
+	assert.Negative(t, compareNatural("img_2.jpg", "IMG_10.jpg"))
+	assert.Positive(t, compareNatural("IMG_10.jpg", "IMG_9.jpg"))
+	assert.Negative(t, compareNatural("IMG_02.jpg", "IMG_3.jpg"))
+	assert.Negative(t, compareNatural("IMG_2.jpg", "IMG_02.jpg"))
+	assert.Negative(t, compareNatural("a", "a1"))
+	assert.Negative(t, compareNatural("99999999999999999999.jpg", "100000000000000000000.jpg"))
+	assert.Zero(t, compareNatural("Photo.jpg", "photo.jpg"))
+	assert.NotZero(t, compareNames("Photo.jpg", "photo.jpg"))
+}
+
+func TestSortFiles(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	files := []File{
+		{Name: "IMG_10.jpg", ModTime: day(1), Metadata: Metadata{DateTime: day(5)}},
+		{Name: "IMG_2.jpg", ModTime: day(3)},
+		{Name: "b.jpg", ModTime: day(2), Metadata: Metadata{DateTime: day(4)}},
+		{Name: "A.jpg", ModTime: day(2)},
+	}
+	names := func() []string {
+		result := []string{}
+		for _, file := range files {
+			result = append(result, file.Name)
+		}
+		return result
+	}
+
+	tests := []struct {
+		order  string
+		manual []string
+		want   []string
+	}{
+		{"new", nil, []string{"IMG_2.jpg", "A.jpg", "b.jpg", "IMG_10.jpg"}},
+		{"old", nil, []string{"IMG_10.jpg", "A.jpg", "b.jpg", "IMG_2.jpg"}},
+		{"date-new", nil, []string{"IMG_10.jpg", "b.jpg", "IMG_2.jpg", "A.jpg"}},
+		{"date-old", nil, []string{"A.jpg", "IMG_2.jpg", "b.jpg", "IMG_10.jpg"}},
+		{"alphabetical", nil, []string{"A.jpg", "b.jpg", "IMG_10.jpg", "IMG_2.jpg"}},
+		{"natural", nil, []string{"A.jpg", "b.jpg", "IMG_2.jpg", "IMG_10.jpg"}},
+		{"manual", []string{"b.jpg", "missing.jpg", "IMG_10.jpg"}, []string{"b.jpg", "IMG_10.jpg", "A.jpg", "IMG_2.jpg"}},
+	}
+	for _, test := range tests {
+		sortFiles(files, test.order, test.manual)
+		assert.Equal(t, test.want, names(), test.order)
+	}
+}
+
+func TestSortSubDirs(t *testing.T) {
+	subDirs := []SubDir{
+		{Name: "trip10", Latest: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "trip2", Latest: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "archive", Sort: 1, Latest: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	sortSubDirs(subDirs, "natural", nil)
+	assert.Equal(t, []string{"trip2", "trip10", "archive"}, []string{subDirs[0].Name, subDirs[1].Name, subDirs[2].Name})
+	sortSubDirs(subDirs, "new", nil)
+	assert.Equal(t, []string{"trip10", "trip2", "archive"}, []string{subDirs[0].Name, subDirs[1].Name, subDirs[2].Name})
+}
+
+func TestReadOrderFile(t *testing.T) {
+	tempDir := t.TempDir()
+	names, err := readOrderFile(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	err = os.WriteFile(filepath.Join(tempDir, orderFile), []byte("# Best first\nsunset.jpg\n\n  trip  \n"), 0644)
+	assert.NoError(t, err)
+	names, err = readOrderFile(tempDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sunset.jpg", "trip"}, names)
+
+	// The order file is read along with the folder config
+	folder, err := readFolderConfig(tempDir, rootFolderConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, names, folder.Order)
+}
@@ -1,36 +1,41 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io/fs"
 	"log/slog"
-	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
-	"time"
 )
 
 // process walks the original directory, processes images, and generates HTML files for each directory.
-func process() error {
+// Files that fail to process are skipped or stop the build, depending on the
+// on_error setting, and are returned together as a *buildError. Cancelling the
+// context stops the build once the files being written are complete.
+func process(ctx context.Context) error {
 	slog.Debug("Processing content")
 
 	err := checkOrCreateOutputDir()
 	if err != nil {
-		slog.Error("Failed to check or create output directory", "error", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to check or create output directory: %w", err)
 	}
 
+	manifest = loadManifest()
+	pubDates = loadPubDates()
+	// Entries are overwritten as outputs are regenerated, so remember what was
+	// generated before, to prune the files that are no longer expected
+	previousFiles := manifest.files()
+
 	numRoutines := runtime.NumCPU()
 
-	done := make(chan struct{})
-	rssDone := make(chan struct{})
-	imageTasks := make(chan string)
+	imageTasks := make(chan imageTask)
 	htmlTasks := make(chan Dir)
+	coverTasks := make(chan coverTask)
 	rssTasks := make(chan RSSItem, numRoutines)
-
-	defer close(imageTasks)
-	defer close(htmlTasks)
+	errs := newErrorCollector()
 
 	wg := &sync.WaitGroup{}
 	slog.Debug("Created wait group", "waitGroup", wg)
@@ -42,158 +47,343 @@ func process() error {
 	for range numRoutines {
 		slog.Debug("Starting image processing goroutines", "numRoutines", numRoutines)
 		wg.Add(1)
-		go processImage(imageTasks, rssTasks, wg, done)
+		go processImage(ctx, imageTasks, rssTasks, errs.errs, wg)
+	}
+
+	// Start the cover processing goroutines
+	for range numRoutines {
+		slog.Debug("Starting cover processing goroutines", "numRoutines", numRoutines)
+		wg.Add(1)
+		go processCover(ctx, coverTasks, errs.errs, wg)
 	}
 
 	// Start the HTML processing goroutines
 	for range numRoutines {
 		slog.Debug("Starting HTML processing goroutines", "numRoutines", numRoutines)
 		wg.Add(1)
-		go processHTMLFile(htmlTasks, wg, done)
+		go processHTMLFile(ctx, htmlTasks, errs.errs, wg)
 	}
 
 	// Start the RSS feed processing goroutine
 	slog.Debug("Starting RSS feed processing goroutine")
 	rssWg.Add(1)
-	go processRSSFeed(rssTasks, rssWg, rssDone)
+	go processRSSFeed(ctx, rssTasks, errs.errs, rssWg)
 
 	galleryContent := DirMap{}
+	expected := newExpectedOutput()
 	// Walk the original directory and send image tasks to the channel
-	err = filepath.WalkDir(config.Originals, func(path string, d fs.DirEntry, err error) error {
+	walkErr := filepath.WalkDir(config.Originals, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if errs.stopBuild() {
+			slog.Debug("Stopping the walk after a failure")
+			return filepath.SkipAll
+		}
 		if err != nil {
-			return err
+			if d == nil {
+				// The originals directory itself can't be read
+				return err
+			}
+			errs.errs <- &fileError{Path: path, Err: err}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		slog.Debug("Processing path", "path", path)
 
 		name := d.Name()
 		fileInfo, err := d.Info()
 		if err != nil {
-			return err
+			errs.errs <- &fileError{Path: path, Err: err}
+			return nil
 		}
 		modTime := fileInfo.ModTime()
 
-		thumbModTime := time.Time{}
-
 		parentDir := filepath.Dir(path)
 		outputDir := filepath.Join(config.Output, strings.TrimPrefix(parentDir, config.Originals))
 
-		needsUpdate := false
-
 		if d.IsDir() {
 			slog.Debug("Processing directory", "path", path, "name", name)
-			outputIndex := filepath.Join(outputDir, "index.html")
+			// Whether the index page needs an update is decided from the manifest once the walk is done
+			galleryContent.AddDir(path, name, false)
 
-			// Check if the output directory exists and if it's newer than the original directory
-			// If it doesn't exist or is older, we need to update the HTML file for it
-			outputIndexInfo, err := os.Stat(outputIndex)
+			// The folder config is merged on top of the settings inherited from the parent directory
+			parent, hasParent := galleryContent[parentDir]
+			inherited := rootFolderConfig()
+			if hasParent {
+				inherited = parent.Folder
+			}
+			folder, err := readFolderConfig(path, inherited)
 			if err != nil {
-				if os.IsNotExist(err) {
-					slog.Debug("Output index does not exist", "outputIndex", outputIndex)
-					needsUpdate = true
-				}
-			} else {
-				if modTime.After(outputIndexInfo.ModTime()) {
-					slog.Debug("Original directory is newer", "originalDir", path, "outputIndex", outputIndex)
-					needsUpdate = true
-				} else {
-					slog.Debug("Output index is newer", "originalDir", path, "outputIndex", outputIndex)
-				}
+				errs.errs <- &fileError{Path: path, Err: err}
 			}
-
-			galleryContent.AddDir(path, name, needsUpdate)
+			dir := galleryContent[path]
+			dir.Folder = folder
+			galleryContent[path] = dir
 
 			// Add the directory to the parent directory's subdirectories
-			if _, ok := galleryContent[parentDir]; ok {
+			if hasParent {
 				slog.Debug("Adding subdirectory", "path", path, "name", name)
-				galleryContent[parentDir].SubDirs[path] = SubDir{
-					Name: name,
+				parent.SubDirs[path] = SubDir{
+					Name:   name,
+					Title:  folder.Title,
+					Sort:   folder.Sort,
+					Hidden: folder.Hidden,
 				}
 			}
 		} else {
 			slog.Debug("Processing file", "path", path, "name", name)
-			if strings.HasSuffix(name, ".jpg") || strings.HasSuffix(name, ".jpeg") {
-				for _, size := range []string{"thumb", "full"} {
-					outputFile := filepath.Join(outputDir, size+"_"+name)
-					outputFileInfo, err := os.Stat(outputFile)
-					if err != nil {
-						if os.IsNotExist(err) {
-							slog.Debug("Output file does not exist", "outputFile", outputFile)
-							needsUpdate = true
-						} else {
-							slog.Error("Failed to stat output file", "error", err)
-							os.Exit(1)
-						}
-					} else {
-						if modTime.After(outputFileInfo.ModTime()) {
-							slog.Debug("Original file is newer", "originalFile", path, "outputFile", outputFile)
-							needsUpdate = true
-						} else {
-							slog.Debug("Output file is newer", "originalFile", path, "outputFile", outputFile)
-							if size == "thumb" {
-								thumbModTime = outputFileInfo.ModTime()
-							}
-						}
-					}
+			dir, ok := galleryContent[parentDir]
+			if !ok {
+				// WalkDir visits every directory before its files, so this only happens if the paths don't match
+				errs.errs <- &fileError{Path: path, Err: fmt.Errorf("directory %s is not indexed", parentDir)}
+				return nil
+			}
+			folder := dir.Folder
+			feeds := galleryContent.feeds(parentDir)
+			if _, ok := formatByFile(name); ok {
+				hash, err := hashFile(path)
+				if err != nil {
+					errs.errs <- &fileError{Path: path, Err: fmt.Errorf("failed to hash original file: %w", err)}
+					return nil
+				}
+
+				// The derived images need an update if the original or the settings
+				// changed since they were generated, or if any of them is missing
+				needsUpdate := false
+				entry, ok := manifest.image(path)
+				switch {
+				case !ok:
+					slog.Debug("Original file is not in the manifest", "path", path)
+					needsUpdate = true
+				case entry.Hash != hash:
+					slog.Debug("Original file has changed", "path", path)
+					needsUpdate = true
+				case entry.Settings != imageSettings(folder.ThumbSize):
+					slog.Debug("Image settings have changed", "path", path)
+					needsUpdate = true
+				case !filesExist(outputFiles(outputDir, derivedFiles(name))):
+					slog.Debug("Derived images are missing", "path", path)
+					needsUpdate = true
+				default:
+					slog.Debug("Derived images are up to date", "path", path)
+				}
+
+				expected.Images[manifestKey(path)] = true
+				expected.addFiles(outputFiles(outputDir, derivedFiles(name)))
+
+				metadata, err := readMetadata(path)
+				if err != nil {
+					slog.Warn("Failed to read image metadata", "path", path, "error", err)
 				}
 				if needsUpdate {
-					imageTasks <- path
-				} else {
-					// Add the file to the RSS feed if it exists and we know the thumbnail size
-					// If the thumbnail size is 0, processImage will add it to the RSS feed instead
-					baseURL := config.GalleryURL + filepath.Join(config.GalleryPath, strings.TrimPrefix(outputDir, config.Output))
-					imageURL := baseURL + "/#" + name
-					thumbURL := baseURL + "/thumb_" + name
-					rssTasks <- RSSItem{
-						Title:       name,
-						Description: "&lt;img src=\"" + thumbURL + "\" alt=\"" + name + "\" /&gt;",
-						Link:        imageURL,
-						PubDate:     thumbModTime.Format(time.RFC1123Z),
-						GUID:        imageURL,
+					select {
+					case imageTasks <- imageTask{Path: path, Hash: hash, ThumbSize: folder.ThumbSize, Feeds: feeds}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				} else if len(feeds) > 0 {
+					// Add the file to its feeds, dated when it was first published, or
+					// when its derived images were generated if it isn't registered yet
+					// Updated images are added to the feeds by processImage instead
+					item := feedItem(path, hash, outputDir, name, metadata, entry.Generated)
+					item.Feeds = feeds
+					select {
+					case rssTasks <- item:
+					case <-ctx.Done():
+						return ctx.Err()
 					}
 				}
 				slog.Debug("Adding file to directory index", "path", path, "name", name)
-				galleryContent[parentDir].Files[path] = File{
-					Name:    name,
-					ModTime: modTime,
+				dir.Files[path] = File{
+					Name:     name,
+					ModTime:  modTime,
+					Hash:     hash,
+					Metadata: metadata,
 				}
+			} else if isFolderConfigFile(name) {
+				slog.Debug("Skipping folder config file", "path", path)
 			} else {
-				slog.Debug("Ignoring non-jpg file", "path", path)
+				slog.Debug("Ignoring unsupported file", "path", path)
 			}
 		}
 		return nil
 	})
-	if err != nil {
-		slog.Error("Failed to walk original directory", "error", err)
-		os.Exit(1)
+
+	// Now that every directory is known, count the images of each, including
+	// those of its subdirectories, and pick its cover
+	stats := galleryContent.folderStats()
+	covers := map[string]string{}
+	for path, dir := range galleryContent {
+		covers[path] = coverSource(dir, stats[path])
+	}
+	for path, dir := range galleryContent {
+		if covers[path] != "" {
+			dir.Cover = coverName(covers[path])
+			dir.CoverSource = covers[path]
+		}
+		for subPath, subDir := range dir.SubDirs {
+			subDir.Images = stats[subPath].Images
+			subDir.Latest = stats[subPath].Latest
+			if covers[subPath] != "" {
+				subDir.Cover = coverName(covers[subPath])
+			}
+			dir.SubDirs[subPath] = subDir
+		}
+		galleryContent[path] = dir
 	}
 
-	for _, dir := range galleryContent {
+	for path, dir := range galleryContent {
+		if walkErr != nil || ctx.Err() != nil {
+			break
+		}
+		if errs.stopBuild() {
+			slog.Debug("Skipping the remaining index pages after a failure")
+			break
+		}
+		if len(dir.Files) == 0 && len(dir.SubDirs) == 0 {
+			slog.Debug("Skipping empty directory", "dir", dir)
+			continue
+		}
+		outputDir := filepath.Join(config.Output, strings.TrimPrefix(path, config.Originals))
+
+		// The cover needs an update if the original it's derived from or the
+		// settings changed since it was generated, or if it's missing
+		if source := covers[path]; source != "" {
+			coverFiles := outputFiles(outputDir, []string{dir.Cover})
+			expected.Covers[manifestKey(path)] = true
+			expected.addFiles(coverFiles)
+			task := coverTask{
+				Dir:       path,
+				Source:    source,
+				Hash:      galleryContent[filepath.Dir(source)].Files[source].Hash,
+				ThumbSize: dir.Folder.ThumbSize,
+			}
+			entry, ok := manifest.cover(path)
+			if !ok || entry.Hash != task.Hash || entry.Settings != coverSettings(source, task.ThumbSize) || !filesExist(coverFiles) {
+				slog.Debug("Adding directory to cover tasks", "dir", path, "source", source)
+				select {
+				case coverTasks <- task:
+				case <-ctx.Done():
+				}
+			}
+		}
+
+		// The feeds of the directory are written even if they have no items,
+		// as its pages link to them, so they're registered with the feeds
+		if feed, ok := dir.folderFeed(); ok {
+			select {
+			case rssTasks <- RSSItem{Feeds: []folderFeed{feed}}:
+			case <-ctx.Done():
+			}
+			for _, format := range enabledFeeds() {
+				feedFiles := outputFiles(outputDir, []string{format.File})
+				expected.Feeds[feedFiles[0]] = true
+				expected.addFiles(feedFiles)
+			}
+		}
+
+		indexFiles := outputFiles(outputDir, pageFiles(dir))
+		expected.Pages[manifestKey(path)] = true
+		expected.addFiles(indexFiles)
+
+		// The pages need an update if the directory content or the settings
+		// changed since they were generated, or if any of them is missing
+		dir.Hash = pageHash(dir)
+		entry, ok := manifest.page(path)
+		dir.NeedsUpdate = !ok || entry.Hash != dir.Hash || !filesExist(indexFiles)
 		if dir.NeedsUpdate {
-			slog.Debug("Processing directory", "dir", dir)
-			if len(dir.Files) > 0 || len(dir.SubDirs) > 0 {
-				slog.Debug("Adding directory to HTML tasks", "dir", dir)
-				htmlTasks <- dir
+			slog.Debug("Adding directory to HTML tasks", "dir", dir)
+			select {
+			case htmlTasks <- dir:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	for _, format := range enabledFeeds() {
+		expected.Feeds[format.File] = true
+		expected.addFiles([]string{format.File})
+	}
+
+	// The sitemap lists every page, so it's written once every directory is known
+	if config.Sitemap && walkErr == nil && ctx.Err() == nil && !errs.stopBuild() {
+		urls := galleryContent.sitemapURLs()
+		expected.Sitemaps[sitemapFile] = true
+		expected.addFiles(sitemapFiles(len(urls)))
+		if err := writeSitemap(urls); err != nil {
+			errs.errs <- &fileError{Path: filepath.Join(config.Output, sitemapFile), Err: err}
+		}
+		if config.RobotsTxt {
+			expected.Sitemaps[robotsFile] = true
+			expected.addFiles([]string{robotsFile})
+			if err := writeRobots(); err != nil {
+				errs.errs <- &fileError{Path: filepath.Join(config.Output, robotsFile), Err: err}
 			}
 		}
 	}
 
-	// Close the image and HTML done channel
-	slog.Debug("Closing image tasks channel")
-	close(done)
+	// Close the image, cover and HTML tasks channels, and let the workers finish
+	slog.Debug("Closing image, cover and HTML tasks channels")
+	close(imageTasks)
+	close(coverTasks)
+	close(htmlTasks)
 	slog.Debug("Waiting for image tasks to finish")
 	wg.Wait()
 
-	// Close the RSS done channel once all image tasks are done
+	// Close the RSS tasks channel once all image tasks are done
 	slog.Debug("Closing RSS tasks channel")
-	close(rssDone)
+	close(rssTasks)
 	slog.Debug("Waiting for RSS tasks to finish")
 	rssWg.Wait()
 
+	failure := errs.close()
+
+	if ctx.Err() != nil {
+		// Every output is written atomically, and only recorded once complete,
+		// so saving the manifest keeps the completed work for the next build
+		slog.Warn("Build interrupted, saving the manifest of the completed files")
+		err = manifest.save()
+		if err != nil {
+			return err
+		}
+		err = savePubDates()
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("build interrupted: %w", ctx.Err())
+	}
+	if walkErr != nil {
+		return fmt.Errorf("failed to walk original directory: %w", walkErr)
+	}
+
 	err = updateTemplateFiles()
 	if err != nil {
 		return err
 	}
 
+	// A failed walk or a stopped build leaves out expected files, so only prune after a clean build
+	if failure == nil {
+		err = pruneOutput(previousFiles, expected)
+		if err != nil {
+			return err
+		}
+	} else {
+		slog.Warn("Skipping pruning, as some files failed to process")
+	}
+
+	// The manifest is saved even if some files failed, so what did succeed isn't regenerated
+	err = manifest.save()
+	if err != nil {
+		return err
+	}
+	err = savePubDates()
+	if err != nil {
+		return err
+	}
+
 	slog.Debug("Processing completed")
-	return nil
+	return failure
 }
@@ -1,20 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"image"
 	"image/jpeg"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/anthonynsimon/bild/imgio"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestProcess(t *testing.T) {
-	// Set up temporary directories for testing
-	tempDir := t.TempDir()
-	config.Output = filepath.Join(tempDir, "output")
-	config.Originals = filepath.Join(tempDir, "originals")
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
 	config.ThumbSize = 100
 	config.FullSize = 800
 	config.JPEGQuality = 90
@@ -55,7 +58,7 @@ func TestProcess(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Run the process function
-	err = process()
+	err = process(context.Background())
 	assert.NoError(t, err)
 
 	// Verify that the output directory was created
@@ -91,10 +94,8 @@ func TestProcess(t *testing.T) {
 }
 
 func TestProcessWithCopyOriginals(t *testing.T) {
-	// Set up temporary directories for testing
-	tempDir := t.TempDir()
-	config.Output = filepath.Join(tempDir, "output")
-	config.Originals = filepath.Join(tempDir, "originals")
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
 	config.CopyOriginals = true
 
 	// Create the originals directory
@@ -117,7 +118,7 @@ func TestProcessWithCopyOriginals(t *testing.T) {
 	}
 
 	// Run the process function
-	err = process()
+	err = process(context.Background())
 	assert.NoError(t, err)
 
 	// Verify that the original images were copied to the output directory
@@ -129,3 +130,834 @@ func TestProcessWithCopyOriginals(t *testing.T) {
 		assert.NoError(t, err)
 	}
 }
+
+func TestProcessWithMixedFormats(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+
+	// Create the originals directory
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+
+	// Create an upper case JPEG, a PNG, and an unsupported file
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	err = imgio.Save(filepath.Join(config.Originals, "IMG_0001.JPG"), img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(config.Originals, "screenshot.png"), img, imgio.PNGEncoder())
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(config.Originals, "notes.txt"), []byte("notes"), 0644)
+	assert.NoError(t, err)
+
+	// Run the process function
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// Verify that the derived images were created in the output format
+	for _, name := range []string{"thumb_IMG_0001.JPG", "full_IMG_0001.JPG", "thumb_screenshot.png.jpg", "full_screenshot.png.jpg"} {
+		_, err = os.Stat(filepath.Join(config.Output, name))
+		assert.NoError(t, err, name)
+	}
+	_, err = os.Stat(filepath.Join(config.Output, "thumb_notes.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Verify that the index links the derived images
+	content, err := os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `src="thumb_screenshot.png.jpg"`)
+	assert.Contains(t, string(content), `<img src="full_screenshot.png.jpg"`)
+}
+
+func TestProcessWithVariantFormats(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.OutputFormats = []string{"webp"}
+
+	// Create the originals directory with a single image
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	err = imgio.Save(filepath.Join(config.Originals, "image1.jpg"), img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	// Run the process function
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// Verify that the derived images were created in both formats
+	for _, name := range []string{"thumb_image1.jpg", "full_image1.jpg", "thumb_image1.jpg.webp", "full_image1.jpg.webp"} {
+		_, err = os.Stat(filepath.Join(config.Output, name))
+		assert.NoError(t, err, name)
+	}
+	variant, err := imgio.Open(filepath.Join(config.Output, "full_image1.jpg.webp"))
+	assert.NoError(t, err)
+	assert.Equal(t, 800, variant.Bounds().Dx())
+
+	// Verify that the index offers the WebP variant with the JPEG fallback
+	content, err := os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<source type="image/webp" srcset="thumb_image1.jpg.webp"><img src="thumb_image1.jpg"`)
+	assert.Contains(t, string(content), `<source type="image/webp" srcset="full_image1.jpg.webp"><img src="full_image1.jpg"`)
+}
+
+func TestProcessWithResponsiveSizes(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.Sizes = []ImageSize{{Name: "small", Width: 160}, {Name: "w320", Width: 320}, {Name: "huge", Width: 1000}}
+
+	// Create the originals directory with a single image
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	err = imgio.Save(filepath.Join(config.Originals, "image1.jpg"), img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	// Run the process function
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// Verify that every size was generated with the right dimensions, without upscaling
+	for name, width := range map[string]int{"small_image1.jpg": 160, "w320_image1.jpg": 320, "huge_image1.jpg": 400} {
+		resized, err := imgio.Open(filepath.Join(config.Output, name))
+		assert.NoError(t, err, name)
+		assert.Equal(t, width, resized.Bounds().Dx(), name)
+		assert.Equal(t, width/2, resized.Bounds().Dy(), name)
+	}
+
+	// Verify that the index uses the sizes in srcset attributes, with the
+	// full size image in the lightbox
+	content, err := os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<img src="thumb_image1.jpg" srcset="small_image1.jpg 160w, w320_image1.jpg 320w, huge_image1.jpg 400w" sizes="(max-width: 600px) 120px, 250px"`)
+	assert.Contains(t, string(content), `<img src="full_image1.jpg" srcset="small_image1.jpg 160w, w320_image1.jpg 320w, huge_image1.jpg 400w, full_image1.jpg 800w" sizes="100vw"`)
+
+	// Changing the full size updates the width of the full size image in the pages
+	config.FullSize = 600
+	err = process(context.Background())
+	assert.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `huge_image1.jpg 400w, full_image1.jpg 600w" sizes="100vw"`)
+}
+
+// setupTestConfig resets the configuration to the defaults, as if loaded from
+// a missing config file in a temporary directory, which it returns, with the
+// originals and output directories in it. The defaults are restored when the
+// test ends, so a test never depends on the configuration left by another.
+func setupTestConfig(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	err := LoadConfig(filepath.Join(tempDir, "config.yml"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { LoadConfig("nonexistent.yaml") })
+
+	config.Output = filepath.Join(tempDir, "output")
+	config.Originals = filepath.Join(tempDir, "originals")
+	return tempDir
+}
+
+// setupCorruptOriginals creates an originals directory with a valid image
+// and a corrupt one, and returns the path of the corrupt one.
+func setupCorruptOriginals(t *testing.T) string {
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.JPEGQuality = 90
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(config.Originals, "image1.jpg"), image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	corruptPath := filepath.Join(config.Originals, "corrupt.jpg")
+	err = os.WriteFile(corruptPath, []byte("not a jpeg"), 0644)
+	assert.NoError(t, err)
+	return corruptPath
+}
+
+func TestProcessOnErrorSkip(t *testing.T) {
+	corruptPath := setupCorruptOriginals(t)
+	config.OnError = "skip"
+
+	// The corrupt image is reported, while the rest of the gallery is still built
+	err := process(context.Background())
+	var failed *buildError
+	assert.ErrorAs(t, err, &failed)
+	assert.False(t, failed.Stopped)
+	assert.Len(t, failed.Failures, 1)
+	assert.Equal(t, corruptPath, failed.Failures[0].Path)
+	assert.Contains(t, err.Error(), "1 file(s) failed to process")
+	assert.Contains(t, err.Error(), corruptPath+": failed to open image")
+
+	for _, name := range []string{"thumb_image1.jpg", "full_image1.jpg", "index.html", manifestFile} {
+		_, err = os.Stat(filepath.Join(config.Output, name))
+		assert.NoError(t, err, name)
+	}
+
+	// The failed image isn't recorded in the manifest, so it's retried on the next build
+	_, ok := manifest.image(corruptPath)
+	assert.False(t, ok)
+	err = process(context.Background())
+	assert.ErrorAs(t, err, &failed)
+	assert.Len(t, failed.Failures, 1)
+}
+
+func TestProcessOnErrorFail(t *testing.T) {
+	setupCorruptOriginals(t)
+	config.OnError = "fail"
+
+	err := process(context.Background())
+	var failed *buildError
+	assert.ErrorAs(t, err, &failed)
+	assert.True(t, failed.Stopped)
+	assert.NotEmpty(t, failed.Failures)
+	assert.Contains(t, err.Error(), "stopped at the first failure")
+}
+
+func TestProcessCancelled(t *testing.T) {
+	setupCorruptOriginals(t)
+
+	// A cancelled build stops without pruning or reporting failures
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := process(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	var failed *buildError
+	assert.False(t, errors.As(err, &failed))
+
+	// Only the manifest of the (empty) completed work is written
+	entries, err := os.ReadDir(config.Output)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.Equal(t, manifestFile, entry.Name())
+	}
+
+	// The next build completes what was left
+	err = os.Remove(filepath.Join(config.Originals, "corrupt.jpg"))
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.Output, "full_image1.jpg"))
+	assert.NoError(t, err)
+}
+
+func TestProcessIncremental(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.JPEGQuality = 90
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+
+	// Create the originals directory with a single image
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	originalPath := filepath.Join(config.Originals, "image1.jpg")
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	err = imgio.Save(originalPath, img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	// Run the process function and verify the manifest was written
+	err = process(context.Background())
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.Output, manifestFile))
+	assert.NoError(t, err)
+
+	// Replace the thumbnail with a marker, so we can tell if it's regenerated
+	thumbPath := filepath.Join(config.Output, "thumb_image1.jpg")
+	markThumb := func() {
+		err := os.WriteFile(thumbPath, []byte("marker"), 0644)
+		assert.NoError(t, err)
+	}
+	thumbRegenerated := func() bool {
+		content, err := os.ReadFile(thumbPath)
+		assert.NoError(t, err)
+		return string(content) != "marker"
+	}
+
+	// A newer modification time alone doesn't regenerate the images
+	markThumb()
+	future := time.Now().Add(time.Hour)
+	err = os.Chtimes(originalPath, future, future)
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, thumbRegenerated())
+
+	// Changing the image settings regenerates the images
+	config.JPEGQuality = 80
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, thumbRegenerated())
+	config.JPEGQuality = 90
+
+	// Changing the original regenerates the images
+	err = process(context.Background())
+	assert.NoError(t, err)
+	markThumb()
+	err = imgio.Save(originalPath, image.NewRGBA(image.Rect(0, 0, 300, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, thumbRegenerated())
+
+	// A missing derived image is regenerated
+	err = os.Remove(filepath.Join(config.Output, "full_image1.jpg"))
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.Output, "full_image1.jpg"))
+	assert.NoError(t, err)
+}
+
+func TestProcessConfigChanges(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.Name = "Test Gallery"
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.JPEGQuality = 90
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+	config.RSSFeed = true
+
+	// Create the originals directory with a single image
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	err = imgio.Save(filepath.Join(config.Originals, "image1.jpg"), img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	// Run the process function, and replace the pages and feed with markers
+	// The feed items are described from the derived images, so the thumbnail
+	// is marked by its modification time instead
+	err = process(context.Background())
+	assert.NoError(t, err)
+	outputs := []string{"thumb_image1.jpg", "index.html", "rss.xml"}
+	marked := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = os.Chtimes(filepath.Join(config.Output, "thumb_image1.jpg"), marked, marked)
+	assert.NoError(t, err)
+	for _, output := range outputs[1:] {
+		err = os.WriteFile(filepath.Join(config.Output, output), []byte("marker"), 0644)
+		assert.NoError(t, err)
+	}
+	regenerated := func(output string) bool {
+		if output == "thumb_image1.jpg" {
+			info, err := os.Stat(filepath.Join(config.Output, output))
+			assert.NoError(t, err)
+			return !info.ModTime().Equal(marked)
+		}
+		content, err := os.ReadFile(filepath.Join(config.Output, output))
+		assert.NoError(t, err)
+		return string(content) != "marker"
+	}
+
+	// Nothing is regenerated without changes
+	err = process(context.Background())
+	assert.NoError(t, err)
+	for _, output := range outputs {
+		assert.False(t, regenerated(output), output)
+	}
+
+	// Changing the name regenerates the index page and the feed, but not the images
+	config.Name = "Renamed Gallery"
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, regenerated("thumb_image1.jpg"))
+	assert.True(t, regenerated("index.html"))
+	assert.True(t, regenerated("rss.xml"))
+	config.Name = "Test Gallery"
+}
+
+func TestProcessWithFolderConfig(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.RSSFeed = true
+
+	// A folder with a config file, a hidden folder and a folder listed first
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for _, dir := range []string{"rome", "private", "zurich"} {
+		err := os.MkdirAll(filepath.Join(config.Originals, dir), 0755)
+		assert.NoError(t, err)
+		err = imgio.Save(filepath.Join(config.Originals, dir, dir+".jpg"), img, imgio.JPEGEncoder(90))
+		assert.NoError(t, err)
+	}
+	err := os.WriteFile(filepath.Join(config.Originals, "rome", "folder.yml"), []byte("title: Rome & Lazio\ndescription: Photos from **Rome**.\nthumbnail_size: 50\ncover: rome.jpg\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(config.Originals, "private", "_index.yml"), []byte("hidden: true\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(config.Originals, "zurich", "folder.yml"), []byte("sort: -1\n"), 0644)
+	assert.NoError(t, err)
+
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// The folder page shows the title and rendered description
+	content, err := os.ReadFile(filepath.Join(config.Output, "rome", "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "<title>Rome &amp; Lazio - ")
+	assert.Contains(t, string(content), "<p>Photos from <strong>Rome</strong>.</p>")
+
+	// The thumbnail size is overridden for the folder only
+	thumb, err := imgio.Open(filepath.Join(config.Output, "rome", "thumb_rome.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 50, thumb.Bounds().Dx())
+	thumb, err = imgio.Open(filepath.Join(config.Output, "zurich", "thumb_zurich.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, thumb.Bounds().Dx())
+
+	// The hidden folder is generated, but neither listed nor in the feed, and
+	// the folders are listed by their sort position
+	assert.FileExists(t, filepath.Join(config.Output, "private", "index.html"))
+	content, err = os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), `href="private/"`)
+	assert.Less(t, strings.Index(string(content), `href="zurich/"`), strings.Index(string(content), `href="rome/"`))
+	feed, err := os.ReadFile(filepath.Join(config.Output, "rss.xml"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(feed), "private")
+	assert.Contains(t, string(feed), "rome")
+}
+
+func TestProcessFolderCovers(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+
+	// A folder with an older image, and a newer one in a subfolder
+	trip := filepath.Join(config.Originals, "trip")
+	err := os.MkdirAll(filepath.Join(trip, "day1"), 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for i, path := range []string{filepath.Join(trip, "older.jpg"), filepath.Join(trip, "day1", "newer.jpg")} {
+		err = imgio.Save(path, img, imgio.JPEGEncoder(90))
+		assert.NoError(t, err)
+		modTime := time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		err = os.Chtimes(path, modTime, modTime)
+		assert.NoError(t, err)
+	}
+
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// The cover is a square thumbnail of the newest image, including those of subfolders
+	cover, err := imgio.Open(filepath.Join(config.Output, "trip", "cover_newer.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 100), cover.Bounds())
+	content, err := os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<a href="trip/"><img src="trip/cover_newer.jpg"`)
+	assert.Contains(t, string(content), "2 images")
+
+	// Setting the cover in the folder config replaces the previous cover
+	err = os.WriteFile(filepath.Join(trip, "folder.yml"), []byte("cover: older.jpg\n"), 0644)
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(config.Output, "trip", "cover_older.jpg"))
+	assert.NoFileExists(t, filepath.Join(config.Output, "trip", "cover_newer.jpg"))
+	content, err = os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<img src="trip/cover_older.jpg"`)
+
+	// A folder without images has no cover
+	err = os.RemoveAll(trip)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(config.Originals, "empty", "sub"), 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(config.Originals, "root.jpg"), img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(config.Output, "trip"))
+	content, err = os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<a href="empty/">`)
+	assert.NotContains(t, string(content), "empty/cover_")
+
+	// The links to folders are escaped, whatever their names
+	odd := filepath.Join(config.Originals, `Say "cheese" & smile`)
+	err = os.MkdirAll(odd, 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(odd, "a#1.jpg"), img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<a href="Say%20%22cheese%22%20&amp;%20smile/"><img src="Say%20%22cheese%22%20&amp;%20smile/cover_a%231.jpg"`)
+}
+
+func TestProcessWithManualOrder(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.ImageOrder = "natural"
+
+	err := os.MkdirAll(filepath.Join(config.Originals, "trip"), 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for _, name := range []string{"IMG_10.jpg", "IMG_2.jpg", "IMG_1.jpg"} {
+		err = imgio.Save(filepath.Join(config.Originals, "trip", name), img, imgio.JPEGEncoder(90))
+		assert.NoError(t, err)
+	}
+
+	// The global natural order applies without an order file
+	err = process(context.Background())
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(config.Output, "trip", "index.html"))
+	assert.NoError(t, err)
+	assertOrder(t, string(content), `src="thumb_IMG_1.jpg"`, `src="thumb_IMG_2.jpg"`, `src="thumb_IMG_10.jpg"`)
+
+	// The order file lists the images first, in manual order
+	err = os.WriteFile(filepath.Join(config.Originals, "trip", "folder.yml"), []byte("image_order: manual\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(config.Originals, "trip", orderFile), []byte("IMG_10.jpg\n"), 0644)
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(config.Output, "trip", "index.html"))
+	assert.NoError(t, err)
+	assertOrder(t, string(content), `src="thumb_IMG_10.jpg"`, `src="thumb_IMG_1.jpg"`, `src="thumb_IMG_2.jpg"`)
+}
+
+// assertOrder asserts that the substrings occur in the content in the given order.
+func assertOrder(t *testing.T, content string, substrings ...string) {
+	t.Helper()
+	previous := -1
+	for _, substring := range substrings {
+		i := strings.Index(content, substring)
+		assert.Greater(t, i, previous, substring)
+		previous = i
+	}
+}
+
+func TestProcessWithImagePages(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.ImageOrder = "natural"
+	config.ImagePages = true
+	config.RSSFeed = true
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+
+	err := os.MkdirAll(filepath.Join(config.Originals, "trip"), 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for _, name := range []string{"IMG_1.jpg", "IMG_2.jpg", "IMG_3?.jpg"} {
+		err = imgio.Save(filepath.Join(config.Originals, "trip", name), img, imgio.JPEGEncoder(90))
+		assert.NoError(t, err)
+	}
+
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// Every image gets a page, linked to its neighbours and the folder, escaping their names
+	content, err := os.ReadFile(filepath.Join(config.Output, "trip", "IMG_2.jpg.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<link rel="prev" href="IMG_1.jpg.html">`)
+	assert.Contains(t, string(content), `<link rel="next" href="IMG_3%3F.jpg.html">`)
+	assert.Contains(t, string(content), `<a href="./" id="up">trip</a>`)
+	assert.Contains(t, string(content), `<meta property="og:image" content="https://example.com/trip/full_IMG_2.jpg">`)
+	assert.Contains(t, string(content), `<meta property="og:url" content="https://example.com/trip/IMG_2.jpg.html">`)
+	content, err = os.ReadFile(filepath.Join(config.Output, "trip", "IMG_1.jpg.html"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), `rel="prev"`)
+
+	// The feed links to the image pages
+	feed, err := os.ReadFile(filepath.Join(config.Output, "rss.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(feed), "<link>https://example.com/trip/IMG_3%3F.jpg.html</link>")
+
+	// Disabling image pages removes them
+	config.ImagePages = false
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(config.Output, "trip", "IMG_2.jpg.html"))
+	assert.FileExists(t, filepath.Join(config.Output, "trip", "index.html"))
+}
+
+func TestProcessWithFeeds(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.Name = "Test Gallery"
+	config.Feeds = []string{"atom", "json"}
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(config.Originals, "image1.jpg"), image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// Only the enabled feeds are written, and linked from the pages
+	assert.NoFileExists(t, filepath.Join(config.Output, "rss.xml"))
+	atom, err := os.ReadFile(filepath.Join(config.Output, "atom.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(atom), `<link href="https://example.com/atom.xml" rel="self" type="application/atom+xml" />`)
+	assert.Contains(t, string(atom), "#image1.jpg</id>")
+	feed, err := os.ReadFile(filepath.Join(config.Output, "feed.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(feed), `"content_html": "<img src=`)
+	content, err := os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<link rel="alternate" type="application/atom+xml" title="Test Gallery Atom Feed" href="/atom.xml">`)
+	assert.Contains(t, string(content), `<link rel="alternate" type="application/feed+json" title="Test Gallery JSON Feed" href="/feed.json">`)
+	assert.NotContains(t, string(content), "application/rss+xml")
+
+	// Disabling a feed removes it
+	config.Feeds = []string{"atom"}
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(config.Output, "feed.json"))
+	assert.FileExists(t, filepath.Join(config.Output, "atom.xml"))
+
+	// Every built-in template has the feed templates
+	config.Template = "default-imgid"
+	config.Feeds = []string{"rss", "atom"}
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(config.Output, "rss.xml"))
+	assert.FileExists(t, filepath.Join(config.Output, "atom.xml"))
+}
+
+func TestProcessStablePubDates(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	tempDir := setupTestConfig(t)
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.Template = "default"
+	config.RSSFeed = true
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(config.Originals, "image1.jpg"), image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	// The first build registers the image next to the config file
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(tempDir, pubDatesFile))
+	registry := loadPubDates()
+	entry, ok := registry.Images["image1.jpg"]
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com//#image1.jpg", entry.GUID)
+
+	// Date the image in the past, and rebuild from scratch
+	published := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry.Published = published
+	registry.Images["image1.jpg"] = entry
+	registry.changed = true
+	err = registry.save()
+	assert.NoError(t, err)
+	err = os.RemoveAll(config.Output)
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// The regenerated image keeps its publish date and GUID
+	feed, err := os.ReadFile(filepath.Join(config.Output, "rss.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(feed), "<pubDate>"+published.Format(time.RFC1123Z)+"</pubDate>")
+	assert.Contains(t, string(feed), "<guid>https://example.com//#image1.jpg</guid>")
+}
+
+func TestProcessWithFolderFeeds(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.Name = "Test Gallery"
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.Template = "default"
+	config.RSSFeed = true
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+
+	// A folder with a feed covering its subfolders, which inherit the setting,
+	// one without, and one with a feed of its own but only subfolders
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for _, file := range []string{"kids/kids.jpg", "kids/2026/kids2026.jpg", "trips/trips.jpg", "events/2025/party.jpg"} {
+		err := os.MkdirAll(filepath.Join(config.Originals, filepath.Dir(file)), 0755)
+		assert.NoError(t, err)
+		err = imgio.Save(filepath.Join(config.Originals, file), img, imgio.JPEGEncoder(90))
+		assert.NoError(t, err)
+	}
+	folderFile := filepath.Join(config.Originals, "kids", "folder.yml")
+	err := os.WriteFile(folderFile, []byte("title: Kids\nfeed: subtree\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(config.Originals, "events", "folder.yml"), []byte("feed: folder\n"), 0644)
+	assert.NoError(t, err)
+
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// The folder feed has the images of the folder and its subfolders, and is linked from its page
+	feed, err := os.ReadFile(filepath.Join(config.Output, "kids", "rss.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(feed), "<title>Test Gallery - Kids</title>")
+	assert.Contains(t, string(feed), `<atom:link href="https://example.com/kids/rss.xml" rel="self"`)
+	assert.Contains(t, string(feed), "#kids.jpg</guid>")
+	assert.Contains(t, string(feed), "#kids2026.jpg</guid>")
+	assert.NotContains(t, string(feed), "#trips.jpg</guid>")
+	content, err := os.ReadFile(filepath.Join(config.Output, "kids", "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<link rel="alternate" type="application/rss+xml" title="Kids RSS Feed" href="/kids/rss.xml">`)
+	assert.NoFileExists(t, filepath.Join(config.Output, "trips", "rss.xml"))
+
+	// The setting is inherited, so the subfolder has a feed of its own
+	feed, err = os.ReadFile(filepath.Join(config.Output, "kids", "2026", "rss.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(feed), "#kids2026.jpg</guid>")
+	assert.NotContains(t, string(feed), "#kids.jpg</guid>")
+
+	// A folder feed without items is written anyway, so the link to it works
+	content, err = os.ReadFile(filepath.Join(config.Output, "events", "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `href="/events/rss.xml"`)
+	feed, err = os.ReadFile(filepath.Join(config.Output, "events", "rss.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(feed), "<title>Test Gallery - events</title>")
+	assert.NotContains(t, string(feed), "<item>")
+	assert.FileExists(t, filepath.Join(config.Output, "events", "2025", "rss.xml"))
+
+	// The site-wide feed still has every image
+	feed, err = os.ReadFile(filepath.Join(config.Output, "rss.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(feed), "#trips.jpg</guid>")
+	assert.Contains(t, string(feed), "#kids2026.jpg</guid>")
+
+	// Disabling the folder feed removes it
+	err = os.WriteFile(folderFile, []byte("title: Kids\n"), 0644)
+	assert.NoError(t, err)
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(config.Output, "kids", "rss.xml"))
+	assert.NoFileExists(t, filepath.Join(config.Output, "kids", "2026", "rss.xml"))
+	assert.FileExists(t, filepath.Join(config.Output, "rss.xml"))
+}
+
+func TestProcessWithSitemap(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+	config.Sitemap = true
+	config.RobotsTxt = true
+
+	err := os.MkdirAll(filepath.Join(config.Originals, "trip"), 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(config.Originals, "trip", "image1.jpg"), image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// The sitemap lists the folder pages with their images, and robots.txt points at it
+	sitemap, err := os.ReadFile(filepath.Join(config.Output, "sitemap.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(sitemap), "<loc>https://example.com/</loc>")
+	assert.Contains(t, string(sitemap), "<loc>https://example.com/trip/</loc>")
+	assert.Contains(t, string(sitemap), "<image:loc>https://example.com/trip/full_image1.jpg</image:loc>")
+	robots, err := os.ReadFile(filepath.Join(config.Output, "robots.txt"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(robots), "Sitemap: https://example.com/sitemap.xml\n")
+
+	// Disabling them removes them
+	config.Sitemap = false
+	config.RobotsTxt = false
+	err = process(context.Background())
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(config.Output, "sitemap.xml"))
+	assert.NoFileExists(t, filepath.Join(config.Output, "robots.txt"))
+}
+
+func TestProcessWithSharingMetadata(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.ImagePages = true
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+
+	err := os.MkdirAll(filepath.Join(config.Originals, "rome"), 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(config.Originals, "rome", "image1.jpg"), image.NewRGBA(image.Rect(0, 0, 200, 100)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(config.Originals, "rome", "folder.yml"), []byte("title: Rome\ndescription: Photos from **Rome** & Lazio.\n"), 0644)
+	assert.NoError(t, err)
+
+	err = process(context.Background())
+	assert.NoError(t, err)
+
+	// The folder page describes itself, and is represented by the full size image of its cover
+	content, err := os.ReadFile(filepath.Join(config.Output, "rome", "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<meta name="description" content="Photos from Rome &amp; Lazio.">`)
+	assert.Contains(t, string(content), `<meta property="og:title" content="Rome">`)
+	assert.Contains(t, string(content), `<link rel="canonical" href="https://example.com/rome/">`)
+	assert.Contains(t, string(content), `<meta property="og:image" content="https://example.com/rome/full_image1.jpg">`)
+	assert.Contains(t, string(content), `<meta property="og:image:width" content="800">`)
+	assert.Contains(t, string(content), `<meta property="og:image:height" content="400">`)
+	assert.Contains(t, string(content), `<meta name="twitter:card" content="summary_large_image">`)
+	assert.Contains(t, string(content), `<script type="application/ld+json">{"@context":"https://schema.org","@type":"ImageGallery","name":"Rome"`)
+	assert.Contains(t, string(content), `"associatedMedia":[{"@type":"ImageObject","name":"image1.jpg","contentUrl":"https://example.com/rome/full_image1.jpg"`)
+
+	// The root page is represented by the cover of its subfolder
+	content, err = os.ReadFile(filepath.Join(config.Output, "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<link rel="canonical" href="https://example.com/">`)
+	assert.Contains(t, string(content), `<meta property="og:image" content="https://example.com/rome/full_image1.jpg">`)
+
+	// The image page has the JSON-LD of its image
+	content, err = os.ReadFile(filepath.Join(config.Output, "rome", "image1.jpg.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<meta property="og:image:width" content="800">`)
+	assert.Contains(t, string(content), `"url":"https://example.com/rome/image1.jpg.html","width":800,"height":400`)
+
+	// Changing the full size updates the image dimensions in the pages
+	config.FullSize = 600
+	err = process(context.Background())
+	assert.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(config.Output, "rome", "index.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<meta property="og:image:width" content="600">`)
+	content, err = os.ReadFile(filepath.Join(config.Output, "rome", "image1.jpg.html"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<meta property="og:image:width" content="600">`)
+	assert.Contains(t, string(content), `"width":600,"height":300`)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dryRun makes the build list the orphaned output files instead of removing them.
+var dryRun bool
+
+// expectedOutput tracks the originals, directories, feeds, covers and sitemaps
+// seen during a build, and the output files they are expected to produce.
+type expectedOutput struct {
+	Files    map[string]bool
+	Images   map[string]bool
+	Pages    map[string]bool
+	Feeds    map[string]bool
+	Covers   map[string]bool
+	Sitemaps map[string]bool
+}
+
+// newExpectedOutput returns an empty set of expected output.
+func newExpectedOutput() expectedOutput {
+	return expectedOutput{
+		Files:    map[string]bool{},
+		Images:   map[string]bool{},
+		Pages:    map[string]bool{},
+		Feeds:    map[string]bool{},
+		Covers:   map[string]bool{},
+		Sitemaps: map[string]bool{},
+	}
+}
+
+// addFiles marks output files, relative to the output directory, as expected.
+func (e expectedOutput) addFiles(files []string) {
+	for _, file := range files {
+		e.Files[file] = true
+	}
+}
+
+// files returns all files recorded in the manifest, including the orphaned
+// files left in place by earlier builds.
+func (m *Manifest) files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := append([]string{}, m.Orphaned...)
+	for _, entry := range m.Images {
+		files = append(files, entry.Files...)
+	}
+	for _, entry := range m.Pages {
+		files = append(files, entry.Files...)
+	}
+	for _, entry := range m.Feeds {
+		files = append(files, entry.Files...)
+	}
+	for _, entry := range m.Covers {
+		files = append(files, entry.Files...)
+	}
+	for _, entry := range m.Sitemaps {
+		files = append(files, entry.Files...)
+	}
+	return files
+}
+
+// orphanedFiles returns the given files that aren't expected, sorted and without duplicates.
+func orphanedFiles(files []string, expected expectedOutput) []string {
+	orphaned := map[string]bool{}
+	for _, file := range files {
+		if !expected.Files[file] {
+			orphaned[file] = true
+		}
+	}
+	result := []string{}
+	for file := range orphaned {
+		result = append(result, file)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// forget removes the entries of originals, directories, feeds, covers and sitemaps that are no longer expected.
+func (m *Manifest) forget(expected expectedOutput) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.Images {
+		if !expected.Images[key] {
+			delete(m.Images, key)
+		}
+	}
+	for key := range m.Pages {
+		if !expected.Pages[key] {
+			delete(m.Pages, key)
+		}
+	}
+	for key := range m.Feeds {
+		if !expected.Feeds[key] {
+			delete(m.Feeds, key)
+		}
+	}
+	for key := range m.Covers {
+		if !expected.Covers[key] {
+			delete(m.Covers, key)
+		}
+	}
+	for key := range m.Sitemaps {
+		if !expected.Sitemaps[key] {
+			delete(m.Sitemaps, key)
+		}
+	}
+}
+
+// pruneOutput removes the output files generated by earlier builds that are
+// no longer expected, e.g. because their original was deleted or renamed, and
+// the directories left empty by that. Only files recorded in the manifest
+// (previous holds the files recorded before this build overwrote any entries)
+// are removed, so files added to the output directory by other means are left alone.
+// In a dry run, or with pruning disabled, the orphaned files are kept in the
+// manifest instead, so a later build can still remove them.
+func pruneOutput(previous []string, expected expectedOutput) error {
+	orphaned := orphanedFiles(append(previous, manifest.files()...), expected)
+	slog.Debug("Pruning output directory", "orphanedFiles", len(orphaned), "dryRun", dryRun)
+
+	if dryRun || !config.Prune {
+		for _, file := range orphaned {
+			path := filepath.Join(config.Output, filepath.FromSlash(file))
+			if dryRun {
+				slog.Info("Would remove orphaned file", "file", path)
+			} else {
+				slog.Debug("Keeping orphaned file, pruning is disabled", "file", path)
+			}
+		}
+		manifest.setOrphaned(orphaned)
+		return nil
+	}
+
+	for _, file := range orphaned {
+		path := filepath.Join(config.Output, filepath.FromSlash(file))
+		slog.Info("Removing orphaned file", "file", path)
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyDirs(filepath.Dir(path))
+	}
+	manifest.setOrphaned(nil)
+	manifest.forget(expected)
+	return nil
+}
+
+// setOrphaned records the orphaned files left in place.
+func (m *Manifest) setOrphaned(files []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Orphaned = files
+}
+
+// removeEmptyDirs removes a directory in the output directory if it's empty,
+// and then its parents, stopping at the first non-empty one or the output directory itself.
+func removeEmptyDirs(dir string) {
+	output := filepath.Clean(config.Output) + string(filepath.Separator)
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, output); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		slog.Info("Removing empty directory", "dir", dir)
+		if err := os.Remove(dir); err != nil {
+			slog.Warn("Failed to remove empty directory", "dir", dir, "error", err)
+			return
+		}
+	}
+}
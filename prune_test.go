@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anthonynsimon/bild/imgio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessPrune(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.Prune = true
+
+	// Create two images in the root, and one in a subdirectory
+	subDir := filepath.Join(config.Originals, "subdir")
+	err := os.MkdirAll(subDir, 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for _, path := range []string{
+		filepath.Join(config.Originals, "image1.jpg"),
+		filepath.Join(config.Originals, "image2.jpg"),
+		filepath.Join(subDir, "subimage.jpg"),
+	} {
+		err = imgio.Save(path, img, imgio.JPEGEncoder(90))
+		assert.NoError(t, err)
+	}
+
+	// Run the process function, and add a file of our own to the output
+	err = process(context.Background())
+	assert.NoError(t, err)
+	robots := filepath.Join(config.Output, "robots.txt")
+	err = os.WriteFile(robots, []byte("User-agent: *\n"), 0644)
+	assert.NoError(t, err)
+
+	// Delete an image and the subdirectory
+	err = os.Remove(filepath.Join(config.Originals, "image2.jpg"))
+	assert.NoError(t, err)
+	err = os.RemoveAll(subDir)
+	assert.NoError(t, err)
+
+	orphaned := []string{
+		filepath.Join(config.Output, "thumb_image2.jpg"),
+		filepath.Join(config.Output, "full_image2.jpg"),
+		filepath.Join(config.Output, "subdir", "thumb_subimage.jpg"),
+		filepath.Join(config.Output, "subdir", "full_subimage.jpg"),
+		filepath.Join(config.Output, "subdir", "index.html"),
+	}
+
+	// A dry run leaves the orphaned files in place
+	dryRun = true
+	err = process(context.Background())
+	dryRun = false
+	assert.NoError(t, err)
+	for _, path := range orphaned {
+		_, err = os.Stat(path)
+		assert.NoError(t, err, path)
+	}
+
+	// A real run removes the orphaned files and the empty subdirectory
+	err = process(context.Background())
+	assert.NoError(t, err)
+	for _, path := range orphaned {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	_, err = os.Stat(filepath.Join(config.Output, "subdir"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Expected and user-added files are left alone, and the manifest forgets the deleted originals
+	for _, path := range []string{robots, filepath.Join(config.Output, "thumb_image1.jpg"), filepath.Join(config.Output, "index.html")} {
+		_, err = os.Stat(path)
+		assert.NoError(t, err, path)
+	}
+	assert.NotContains(t, manifest.Images, "image2.jpg")
+	assert.NotContains(t, manifest.Pages, "subdir")
+}
+
+func TestProcessPrune_ChangedSizes(t *testing.T) {
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
+	config.ThumbSize = 100
+	config.FullSize = 800
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	config.Prune = true
+	config.Sizes = []ImageSize{{Name: "small", Width: 160}}
+
+	// Create the originals directory with a single image
+	err := os.MkdirAll(config.Originals, 0755)
+	assert.NoError(t, err)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	err = imgio.Save(filepath.Join(config.Originals, "image1.jpg"), img, imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+
+	// Run the process function with a responsive size, then without it
+	err = process(context.Background())
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.Output, "small_image1.jpg"))
+	assert.NoError(t, err)
+
+	config.Sizes = []ImageSize{}
+	err = process(context.Background())
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.Output, "small_image1.jpg"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(config.Output, "thumb_image1.jpg"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// pubDatesFile is the default name of the publish dates registry, kept next
+// to the config file, so it survives deleting or cleaning the output.
+const pubDatesFile = ".gallery-pubdates.json"
+
+// pubDatesVersion is bumped whenever the registry format changes.
+const pubDatesVersion = 1
+
+// PubDate records when an original was first seen, and the GUID it was first
+// published under, so neither changes when its derived images are regenerated.
+type PubDate struct {
+	Hash      string    `json:"hash"`
+	Published time.Time `json:"published"`
+	GUID      string    `json:"guid"`
+}
+
+// PubDates is the registry of the publish dates of all originals ever seen,
+// keyed by their path relative to the originals directory. Entries of removed
+// originals are kept, so an original that is restored, or moved elsewhere
+// with the same content, keeps its publish date and GUID. The batch is when
+// the current build started, dating the images it publishes, so they can be
+// grouped in the feeds.
+type PubDates struct {
+	Version int                `json:"version"`
+	Images  map[string]PubDate `json:"images"`
+	batch   time.Time
+	changed bool
+	mu      sync.Mutex
+}
+
+// pubDates is the publish dates registry of the current build.
+var pubDates = newPubDates()
+
+// newPubDates returns an empty registry.
+func newPubDates() *PubDates {
+	return &PubDates{
+		Version: pubDatesVersion,
+		Images:  map[string]PubDate{},
+		batch:   time.Now(),
+	}
+}
+
+// pubDatesPath returns the path of the publish dates registry, as configured
+// or next to the config file.
+func pubDatesPath() string {
+	if config.PubDatesFile != "" {
+		return config.PubDatesFile
+	}
+	return filepath.Join(config.dir, pubDatesFile)
+}
+
+// loadPubDates loads the publish dates registry. A missing or unreadable
+// registry results in an empty one, dating the images when they're next seen.
+func loadPubDates() *PubDates {
+	path := pubDatesPath()
+	slog.Debug("Loading publish dates", "path", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read publish dates, images are dated when next seen", "path", path, "error", err)
+		}
+		return newPubDates()
+	}
+	p := newPubDates()
+	err = json.Unmarshal(data, p)
+	if err != nil || p.Version != pubDatesVersion {
+		slog.Warn("Ignoring invalid or outdated publish dates, images are dated when next seen", "path", path, "error", err)
+		return newPubDates()
+	}
+	if p.Images == nil {
+		p.Images = map[string]PubDate{}
+	}
+	return p
+}
+
+// save writes the publish dates registry, if anything changed since it was loaded.
+func (p *PubDates) save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.changed {
+		return nil
+	}
+	path := pubDatesPath()
+	slog.Debug("Saving publish dates", "path", path)
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	p.changed = false
+	return nil
+}
+
+// savePubDates saves the publish dates registry if feeds are enabled, so
+// building a gallery without feeds doesn't leave a registry behind.
+func savePubDates() error {
+	if len(enabledFeeds()) == 0 {
+		return nil
+	}
+	return pubDates.save()
+}
+
+// publish returns the publish date and GUID of an original with the given
+// content hash, registering it with the given date and GUID if it's new.
+// An edited original keeps its entry, and an original with the same content
+// as a registered one that no longer exists takes over its entry, as it was moved.
+func (p *PubDates) publish(original string, hash string, seen time.Time, guid string) PubDate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := manifestKey(original)
+	if entry, ok := p.Images[key]; ok {
+		if entry.Hash != hash {
+			slog.Debug("Original changed, keeping its publish date", "path", original)
+			entry.Hash = hash
+			p.Images[key] = entry
+			p.changed = true
+		}
+		return entry
+	}
+
+	moved := []string{}
+	for oldKey, entry := range p.Images {
+		if entry.Hash == hash {
+			moved = append(moved, oldKey)
+		}
+	}
+	slices.Sort(moved)
+	for _, oldKey := range moved {
+		entry := p.Images[oldKey]
+		if _, err := os.Stat(filepath.Join(config.Originals, filepath.FromSlash(oldKey))); err == nil {
+			continue
+		}
+		slog.Debug("Original moved, keeping its publish date", "from", oldKey, "to", key)
+		delete(p.Images, oldKey)
+		p.Images[key] = entry
+		p.changed = true
+		return entry
+	}
+
+	slog.Debug("Original first seen, registering its publish date", "path", original, "published", seen)
+	entry := PubDate{Hash: hash, Published: seen, GUID: guid}
+	p.Images[key] = entry
+	p.changed = true
+	return entry
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubDatesPublish(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = filepath.Join(tempDir, "output")
+	config.Originals = filepath.Join(tempDir, "originals")
+	err := os.MkdirAll(filepath.Join(config.Originals, "album"), 0755)
+	assert.NoError(t, err)
+	original := filepath.Join(config.Originals, "album", "image1.jpg")
+	err = os.WriteFile(original, []byte("image"), 0644)
+	assert.NoError(t, err)
+
+	first := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	later := first.Add(24 * time.Hour)
+
+	// A new original is registered with the given date and GUID
+	p := newPubDates()
+	entry := p.publish(original, "abc", first, "https://example.com/album/#image1.jpg")
+	assert.Equal(t, first, entry.Published)
+	assert.Equal(t, "https://example.com/album/#image1.jpg", entry.GUID)
+
+	// Seeing it again, or edited, keeps its date and GUID
+	entry = p.publish(original, "abc", later, "https://example.org/album/#image1.jpg")
+	assert.Equal(t, first, entry.Published)
+	assert.Equal(t, "https://example.com/album/#image1.jpg", entry.GUID)
+	entry = p.publish(original, "edited", later, "https://example.com/album/#image1.jpg")
+	assert.Equal(t, first, entry.Published)
+	assert.Equal(t, "edited", p.Images["album/image1.jpg"].Hash)
+
+	// A copy of an existing original is a new image
+	entry = p.publish(filepath.Join(config.Originals, "copy.jpg"), "edited", later, "https://example.com/#copy.jpg")
+	assert.Equal(t, later, entry.Published)
+
+	// A moved original takes over the entry of the original that no longer exists
+	err = os.Remove(original)
+	assert.NoError(t, err)
+	entry = p.publish(filepath.Join(config.Originals, "moved.jpg"), "edited", later, "https://example.com/#moved.jpg")
+	assert.Equal(t, first, entry.Published)
+	assert.Equal(t, "https://example.com/album/#image1.jpg", entry.GUID)
+	assert.NotContains(t, p.Images, "album/image1.jpg")
+}
+
+func TestPubDatesSaveAndLoad(t *testing.T) {
+	tempDir := setupTestConfig(t)
+
+	// The registry is kept next to the config file by default, not in or next
+	// to the output directory, which may be deleted or shared with other sites
+	assert.Equal(t, filepath.Join(tempDir, ".gallery-pubdates.json"), pubDatesPath())
+	config.Output = filepath.Join(tempDir, "site", "output")
+	assert.Equal(t, filepath.Join(tempDir, ".gallery-pubdates.json"), pubDatesPath())
+	config.PubDatesFile = filepath.Join(tempDir, "dates.json")
+	assert.Equal(t, config.PubDatesFile, pubDatesPath())
+
+	// A missing registry results in an empty one, which isn't written until it changes
+	p := loadPubDates()
+	assert.Empty(t, p.Images)
+	err := p.save()
+	assert.NoError(t, err)
+	assert.NoFileExists(t, config.PubDatesFile)
+
+	published := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	p.publish(filepath.Join(config.Originals, "image1.jpg"), "abc", published, "https://example.com/#image1.jpg")
+	err = p.save()
+	assert.NoError(t, err)
+
+	p = loadPubDates()
+	assert.Equal(t, PubDate{Hash: "abc", Published: published, GUID: "https://example.com/#image1.jpg"}, p.Images["image1.jpg"])
+
+	// An invalid registry is ignored
+	err = os.WriteFile(config.PubDatesFile, []byte("not json"), 0644)
+	assert.NoError(t, err)
+	assert.Empty(t, loadPubDates().Images)
+}
@@ -1,126 +1,296 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"html"
+	"io"
 	"log/slog"
-	"os"
-	"path"
+	"maps"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
-	"text/template"
 	"time"
 )
 
-func processRSSFeed(rssTasks <-chan RSSItem, wg *sync.WaitGroup, done <-chan struct{}) {
-	slog.Debug("Starting processRSSFeed goroutine")
-	defer wg.Done()
+// newRSSItem creates the RSS item for an image in the given output directory.
+// The title and description come from the image metadata, falling back to the
+// file name. The size and dimensions of the full size image and the thumbnail
+// are read from the derived images, and left out if they can't be read.
+func newRSSItem(outputDir string, name string, metadata Metadata, pubDate time.Time) RSSItem {
+	baseURL := config.GalleryURL + filepath.Join(config.GalleryPath, strings.TrimPrefix(outputDir, config.Output))
+	imageURL := baseURL + "/#" + name
+	thumbURL := baseURL + "/" + derivedName("thumb", name)
+	// The item links to the image page if there is one, but keeps its GUID,
+	// so enabling image pages doesn't make feed readers show the items again
+	link := imageURL
+	if page := imagePageName(name); page != "" {
+		link = absoluteURL(filepath.ToSlash(strings.TrimPrefix(outputDir, config.Output)), page)
+	}
+
+	// The description is HTML, escaped by the feed templates
+	description := "<img src=\"" + html.EscapeString(thumbURL) + "\" alt=\"" + html.EscapeString(metadata.description(name)) + "\" />"
+	if metadata.Caption != "" {
+		description += "<p>" + html.EscapeString(metadata.Caption) + "</p>"
+	}
 
-	tpl, err := template.ParseGlob(filepath.Join("templates", config.Template, "rss.go.xml"))
+	item := RSSItem{
+		Title:       metadata.title(name),
+		Description: description,
+		Link:        link,
+		PubDate:     pubDate.Format(time.RFC1123Z),
+		Date:        pubDate,
+		GUID:        imageURL,
+	}
+
+	full := derivedName("full", name)
+	length, width, height, err := imageFileInfo(filepath.Join(outputDir, full))
 	if err != nil {
-		slog.Error("Failed to parse template", "error", err)
-		return
+		slog.Debug("Failed to read full size image, leaving out the enclosure", "name", name, "error", err)
+		return item
 	}
-	slog.Debug("Template parsed", "template", tpl)
+	format, _ := formatByFile(full)
+	item.ImageURL = baseURL + "/" + full
+	item.MIMEType = format.MIMEType
+	item.Length = length
+	item.Width = width
+	item.Height = height
+	_, item.ThumbWidth, item.ThumbHeight, err = imageFileInfo(filepath.Join(outputDir, derivedName("thumb", name)))
+	if err == nil {
+		item.ThumbURL = thumbURL
+	}
+	return item
+}
 
-	RSSFeed := RSSFeed{
-		Title:         config.Name,
-		Description:   "Latest images from " + config.Name,
-		Link:          config.GalleryURL + config.GalleryPath,
-		Language:      "en-us",
-		Copyright:     config.Copyright,
-		AtomLink:      config.GalleryURL + path.Join(config.GalleryPath, "rss.xml"),
-		LastBuildDate: time.Now().Format(time.RFC1123Z),
-		Items:         []RSSItem{},
+// feedItem creates the RSS item of an original with the given content hash,
+// dated and identified as when it was first published, registering it as
+// first seen at the given time if it's new. With feed_date set to taken, the
+// item is dated when the image was taken instead, if its metadata records it.
+func feedItem(original string, hash string, outputDir string, name string, metadata Metadata, seen time.Time) RSSItem {
+	item := newRSSItem(outputDir, name, metadata, seen)
+	published := pubDates.publish(original, hash, seen, item.GUID)
+	date := published.Published
+	if config.FeedDate == "taken" && !metadata.DateTime.IsZero() {
+		date = metadata.DateTime
 	}
-	rssFile := filepath.Join(config.Output, "rss.xml")
-	updateRSSFeed := false
+	item.PubDate = date.Format(time.RFC1123Z)
+	item.Date = date
+	item.GUID = published.GUID
+	return item
+}
+
+// processRSSFeed collects the RSS items of all images, and writes the enabled
+// formats of every feed once the tasks channel is closed, unless the context
+// is cancelled first. A feed that fails to write is reported on the errors channel.
+func processRSSFeed(ctx context.Context, rssTasks <-chan RSSItem, errs chan<- error, wg *sync.WaitGroup) {
+	slog.Debug("Starting processRSSFeed goroutine")
+	defer wg.Done()
+
+	// Feeds are keyed by their directory, the site-wide feed being always there
+	now := time.Now()
+	lastBuildDate := now.Format(time.RFC1123Z)
+	feeds := map[string]*RSSFeed{"": newRSSFeed(folderFeed{}, lastBuildDate)}
 
 	for {
 		select {
-		case item := <-rssTasks:
-			// Process the RSS item and add it to the RSS feed
-			if item == (RSSItem{}) {
-				slog.Debug("Received empty RSS item, skipping")
+		case item, ok := <-rssTasks:
+			if ok {
+				// Add the RSS item to each of its feeds. Empty items only register
+				// their feeds, so feeds without any items are written as well
+				for _, scope := range item.Feeds {
+					feed, ok := feeds[scope.Dir]
+					if !ok {
+						feed = newRSSFeed(scope, lastBuildDate)
+						feeds[scope.Dir] = feed
+					}
+					if item.GUID != "" {
+						feed.Items = append(feed.Items, item)
+					}
+				}
+				if item.GUID == "" {
+					slog.Debug("Received empty RSS item, skipping")
+					continue
+				}
+				slog.Debug("RSS item added", "item", item)
 				continue
 			}
-			RSSFeed.Items = append(RSSFeed.Items, item)
-			slog.Debug("RSS item added", "item", item)
-		case <-done:
-			// Sort the RSS items by PubDate
-			if !config.RSSFeed {
-				slog.Debug("RSS feed generation is disabled, skipping")
+
+			// The tasks channel is closed once all images are processed
+			formats := enabledFeeds()
+			if len(formats) == 0 {
+				slog.Debug("Feed generation is disabled, skipping")
 				return
 			}
-			slog.Debug("Sorting RSS items by PubDate", "itemCount", len(RSSFeed.Items))
-			sort.Slice(RSSFeed.Items, func(i, j int) bool {
-				pubDateI, errI := time.Parse(time.RFC1123Z, RSSFeed.Items[i].PubDate)
-				if errI != nil {
-					slog.Error("Failed to parse PubDate for item i", "error", errI)
-					return false
+			for _, dir := range slices.Sorted(maps.Keys(feeds)) {
+				feed := feeds[dir]
+				sortRSSItems(feed.Items)
+				feed.Items = recentItems(feed.Items)
+				if config.FeedDigest {
+					feed.Items = digestItems(*feed)
+				}
+				// Only include the newest items in the feeds
+				if len(feed.Items) > config.FeedMaxItems {
+					slog.Debug("Trimming RSS feed items", "dir", dir, "maxItems", config.FeedMaxItems)
+					feed.Items = feed.Items[:config.FeedMaxItems]
 				}
-				pubDateJ, errJ := time.Parse(time.RFC1123Z, RSSFeed.Items[j].PubDate)
-				if errJ != nil {
-					slog.Error("Failed to parse PubDate for item j", "error", errJ)
-					return false
+				// Feeds are updated with their newest item, or when they're written if they have none
+				feed.Updated = now.Format(time.RFC3339)
+				if len(feed.Items) > 0 {
+					feed.Updated = feed.Items[0].Date.Format(time.RFC3339)
 				}
-				return pubDateI.After(pubDateJ)
-			})
-			// Check if the RSS feed file exists and if it has been modified since the newest item PubDate
-			if len(RSSFeed.Items) > 0 {
-				slog.Debug("Checking if RSS feed file needs to be updated")
-				rssFileInfo, err := os.Stat(rssFile)
-				if err == nil {
-					// File exists, check modification time
-					rssFileModTime := rssFileInfo.ModTime()
-					pubDate, err := time.Parse(time.RFC1123Z, RSSFeed.Items[0].PubDate)
+				for _, format := range formats {
+					err := writeFeed(format, dir, *feed)
 					if err != nil {
-						slog.Error("Failed to parse PubDate for comparison", "error", err)
-						return
+						errs <- &fileError{Path: filepath.Join(config.Output, filepath.FromSlash(dir), format.File), Err: err}
 					}
-					if rssFileModTime.After(pubDate) {
-						slog.Debug("RSS feed file is up to date, skipping write")
-						return
-					} else {
-						slog.Debug("RSS feed file is outdated, updating")
-						updateRSSFeed = true
-					}
-				}
-				// If the file does not exist, we need to create it
-				if os.IsNotExist(err) {
-					slog.Debug("RSS feed file does not exist, creating")
-					updateRSSFeed = true
-				} else if err != nil {
-					slog.Error("Failed to stat RSS feed file", "error", err)
-					return
 				}
 			}
-			if updateRSSFeed {
-				slog.Debug("Updating RSS feed file")
-				// Only include the 100 newest items in the RSS feed
-				if len(RSSFeed.Items) > 100 {
-					slog.Debug("Trimming RSS feed items to 100 items")
-					RSSFeed.Items = RSSFeed.Items[:100]
-				}
-				// Render the RSS feed to a file
-				f, err := os.Create(rssFile)
-				if err != nil {
-					slog.Error("Failed to create RSS feed file", "error", err)
-					return
-				}
-				defer f.Close()
-				slog.Debug("RSS feed file created", "rssFile", rssFile)
-				err = tpl.ExecuteTemplate(f, "rss.go.xml", RSSFeed)
-				if err != nil {
-					slog.Error("Failed to execute template for RSS feed", "error", err)
-					return
-				}
-				slog.Debug("RSS feed file written", "rssFile", rssFile)
-			}
 
-			// Render the RSS feed to a file and return
-			// If the output RSS feed exists, and has been modified since the newest item PubDate, skip writing and return
-			slog.Debug("Received done signal, exiting processRSSFeed goroutine")
+			slog.Debug("RSS tasks channel closed, exiting processRSSFeed goroutine")
+			return
+
+		case <-ctx.Done():
+			slog.Debug("Build cancelled, stopping processRSSFeed goroutine")
 			return
 		}
 	}
 }
+
+// newRSSFeed returns an empty feed, the site-wide one or that of a folder.
+func newRSSFeed(scope folderFeed, lastBuildDate string) *RSSFeed {
+	title := config.FeedTitle
+	if title == "" {
+		title = config.Name
+	}
+	description := config.FeedDescription
+	if description == "" {
+		description = "Latest images from " + title
+	}
+	feed := &RSSFeed{
+		Title:         title,
+		Description:   description,
+		Link:          config.GalleryURL + config.GalleryPath,
+		Language:      config.FeedLanguage,
+		Copyright:     config.Copyright,
+		LastBuildDate: lastBuildDate,
+		Items:         []RSSItem{},
+	}
+	if scope.Dir != "" {
+		feed.Title = title + " - " + scope.Title
+		feed.Description = "Latest images from " + scope.Title
+		feed.Link = folderURL(scope.Dir)
+	}
+	return feed
+}
+
+// sortRSSItems sorts RSS items by PubDate, newest first.
+func sortRSSItems(items []RSSItem) {
+	slog.Debug("Sorting RSS items by PubDate", "itemCount", len(items))
+	sort.Slice(items, func(i, j int) bool {
+		pubDateI, errI := time.Parse(time.RFC1123Z, items[i].PubDate)
+		if errI != nil {
+			slog.Error("Failed to parse PubDate for item i", "error", errI)
+			return false
+		}
+		pubDateJ, errJ := time.Parse(time.RFC1123Z, items[j].PubDate)
+		if errJ != nil {
+			slog.Error("Failed to parse PubDate for item j", "error", errJ)
+			return false
+		}
+		// Items arrive in no particular order, so sort items with the same PubDate by GUID
+		// to keep the feed content, and thereby its fingerprint, stable between builds
+		if pubDateI.Equal(pubDateJ) {
+			return items[i].GUID < items[j].GUID
+		}
+		return pubDateI.After(pubDateJ)
+	})
+}
+
+// recentItems returns the items dated within feed_max_age days, or all of them without a limit.
+func recentItems(items []RSSItem) []RSSItem {
+	if config.FeedMaxAge == 0 {
+		return items
+	}
+	cutoff := time.Now().AddDate(0, 0, -config.FeedMaxAge)
+	return slices.DeleteFunc(items, func(item RSSItem) bool {
+		return item.Date.Before(cutoff)
+	})
+}
+
+// digestItems groups the items of a feed published in the same build into a
+// digest item, titled after feed_digest_title and linking to the feed's page,
+// with the full size image of the newest item as its enclosure. Items
+// published on their own are left as they are. The items must be sorted.
+func digestItems(feed RSSFeed) []RSSItem {
+	items := []RSSItem{}
+	for start := 0; start < len(feed.Items); {
+		end := start + 1
+		for end < len(feed.Items) && feed.Items[end].Date.Equal(feed.Items[start].Date) {
+			end++
+		}
+		batch := feed.Items[start:end]
+		start = end
+		if len(batch) == 1 {
+			items = append(items, batch[0])
+			continue
+		}
+
+		description := ""
+		for _, item := range batch {
+			description += "<p><a href=\"" + html.EscapeString(item.Link) + "\">" + html.EscapeString(item.Title) + "</a></p>" + item.Description
+		}
+		digest := batch[0]
+		digest.Title = fmt.Sprintf(config.FeedDigestTitle, len(batch))
+		digest.Description = description
+		digest.Link = feed.Link
+		digest.GUID = feed.Link + "#digest-" + digest.Date.UTC().Format("20060102T150405Z")
+		digest.Feeds = nil
+		slog.Debug("Digest item added", "title", digest.Title, "guid", digest.GUID)
+		items = append(items, digest)
+	}
+	return items
+}
+
+// writeFeed writes a feed in the given format, in the output directory of the
+// given directory, unless its content and settings haven't changed since it
+// was written, and records it in the manifest. A feed without items is still
+// written, as the pages link to it.
+func writeFeed(format feedFormat, dir string, feed RSSFeed) error {
+	feed.AtomLink = absoluteURL(dir, format.File)
+	outputDir := filepath.Join(config.Output, filepath.FromSlash(dir))
+	feedFile := filepath.Join(outputDir, format.File)
+	key := outputFiles(outputDir, []string{format.File})[0]
+
+	// The build date changes on every build, so it's left out of the
+	// fingerprint, as is the update date of empty feeds, which is the build date
+	content := feed
+	content.LastBuildDate = ""
+	if len(feed.Items) == 0 {
+		content.Updated = ""
+	}
+	hash := fingerprint(content, feedSettings(format))
+	entry, ok := manifest.feed(key)
+	if ok && entry.Hash == hash && filesExist(entry.Files) {
+		slog.Debug("Feed is up to date, skipping write", "feed", key)
+		return nil
+	}
+
+	slog.Debug("Updating feed", "feed", key)
+	write := func(w io.Writer) error { return writeJSONFeed(w, feed) }
+	if format.Template != "" {
+		tpl, err := parseTemplate(format.Template)
+		if err != nil {
+			return fmt.Errorf("failed to parse template: %w", err)
+		}
+		write = func(w io.Writer) error { return tpl.ExecuteTemplate(w, format.Template, feed) }
+	}
+	err := writeFileAtomic(feedFile, write)
+	if err != nil {
+		return fmt.Errorf("failed to write feed file: %w", err)
+	}
+	slog.Debug("Feed file written", "feedFile", feedFile)
+	manifest.setFeed(key, ManifestPage{Hash: hash, Files: []string{key}})
+	return nil
+}
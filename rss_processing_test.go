@@ -1,19 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"image"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/anthonynsimon/bild/imgio"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestProcessRSSFeed(t *testing.T) {
-	// Set up temporary directories for testing
-	tempDir := t.TempDir()
-	config.Output = filepath.Join(tempDir, "output")
+	// Start from the default configuration, with temporary directories for testing
+	tempDir := setupTestConfig(t)
 	config.Template = "default"
 	config.Name = "Test Gallery"
 	config.Copyright = "Test Author"
@@ -21,8 +25,8 @@ func TestProcessRSSFeed(t *testing.T) {
 	config.GalleryPath = "/gallery/"
 	config.RSSFeed = true
 
-	// Create the output directory
-	err := os.MkdirAll(config.Output, 0755)
+	// Create the output directory, with that of a folder with a feed
+	err := os.MkdirAll(filepath.Join(config.Output, "kids"), 0755)
 	assert.NoError(t, err)
 
 	// Create a mock RSS template file
@@ -52,13 +56,13 @@ func TestProcessRSSFeed(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Set up channels and WaitGroup
+	errs := make(chan error, 10)
 	rssTasks := make(chan RSSItem)
-	done := make(chan struct{})
 	var rssWg sync.WaitGroup
 
 	// Start the processRSSFeed function in a goroutine
 	rssWg.Add(1)
-	go processRSSFeed(rssTasks, &rssWg, done)
+	go processRSSFeed(context.Background(), rssTasks, errs, &rssWg)
 
 	var wg sync.WaitGroup
 
@@ -72,6 +76,7 @@ func TestProcessRSSFeed(t *testing.T) {
 			Link:        "https://example.com/gallery/image1.jpg",
 			PubDate:     time.Now().Add(-1 * time.Hour).Format(time.RFC1123Z),
 			GUID:        "image1",
+			Feeds:       []folderFeed{{}},
 		}
 	}()
 	wg.Add(1)
@@ -83,14 +88,15 @@ func TestProcessRSSFeed(t *testing.T) {
 			Link:        "https://example.com/gallery/image2.jpg",
 			PubDate:     time.Now().Add(-2 * time.Hour).Format(time.RFC1123Z),
 			GUID:        "image2",
+			Feeds:       []folderFeed{{}, {Dir: "kids", Title: "Kids & co"}},
 		}
 	}()
 
 	// Wait for the goroutines to finish sending items
 	wg.Wait()
 
-	// Close the rssTasks done channel
-	close(done)
+	// Close the rssTasks channel, writing the feed
+	close(rssTasks)
 	// Wait for the goroutine to finish
 	rssWg.Wait()
 
@@ -107,12 +113,19 @@ func TestProcessRSSFeed(t *testing.T) {
 	assert.Contains(t, string(content), "<description>Latest images from Test Gallery</description>")
 	assert.Contains(t, string(content), "<title>Image 1</title>")
 	assert.Contains(t, string(content), "<title>Image 2</title>")
+
+	// The folder feed only has the items of the folder
+	content, err = os.ReadFile(filepath.Join(config.Output, "kids", "rss.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "<title>Test Gallery - Kids &amp; co</title>")
+	assert.Contains(t, string(content), "<link>https://example.com/gallery/kids/</link>")
+	assert.Contains(t, string(content), "<title>Image 2</title>")
+	assert.NotContains(t, string(content), "<title>Image 1</title>")
 }
 
 func TestProcessRSSFeed_Disabled(t *testing.T) {
-	// Set up temporary directories for testing
-	tempDir := t.TempDir()
-	config.Output = filepath.Join(tempDir, "output")
+	// Start from the default configuration, with temporary directories for testing
+	setupTestConfig(t)
 	config.Template = "default"
 	config.RSSFeed = false // RSS feed generation is disabled
 
@@ -121,13 +134,13 @@ func TestProcessRSSFeed_Disabled(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Set up channels and WaitGroup
+	errs := make(chan error, 10)
 	rssTasks := make(chan RSSItem, 10)
-	done := make(chan struct{})
 	var wg sync.WaitGroup
 
 	// Start the processRSSFeed function in a goroutine
 	wg.Add(1)
-	go processRSSFeed(rssTasks, &wg, done)
+	go processRSSFeed(context.Background(), rssTasks, errs, &wg)
 
 	// Send mock RSS items to the channel
 	rssTasks <- RSSItem{
@@ -138,9 +151,8 @@ func TestProcessRSSFeed_Disabled(t *testing.T) {
 		GUID:        "image1",
 	}
 
-	// Close the rssTasks channel and signal done
+	// Close the rssTasks channel
 	close(rssTasks)
-	close(done)
 
 	// Wait for the goroutine to finish
 	wg.Wait()
@@ -151,3 +163,177 @@ func TestProcessRSSFeed_Disabled(t *testing.T) {
 	assert.Error(t, err) // File should not exist
 	assert.True(t, os.IsNotExist(err))
 }
+
+func TestNewRSSItem(t *testing.T) {
+	config.Output = "output"
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/gallery/"
+	pubDate := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Without metadata the file name is used
+	item := newRSSItem(filepath.Join("output", "album"), "image1.jpg", Metadata{}, pubDate)
+	assert.Equal(t, "image1.jpg", item.Title)
+	assert.Equal(t, "https://example.com/gallery/album/#image1.jpg", item.Link)
+	assert.Equal(t, item.Link, item.GUID)
+	assert.Equal(t, pubDate.Format(time.RFC1123Z), item.PubDate)
+	assert.Equal(t, `<img src="https://example.com/gallery/album/thumb_image1.jpg" alt="image1.jpg" />`, item.Description)
+	// The derived images don't exist, so there's no enclosure
+	assert.Empty(t, item.ImageURL)
+
+	// With IPTC metadata the title and caption are used
+	item = newRSSItem(filepath.Join("output", "album"), "image1.jpg", Metadata{Title: "Sunset", Caption: "Fish & chips"}, pubDate)
+	assert.Equal(t, "Sunset", item.Title)
+	assert.Contains(t, item.Description, `alt="Fish &amp; chips"`)
+	assert.Contains(t, item.Description, `<p>Fish &amp; chips</p>`)
+}
+
+func TestNewRSSItem_Enclosure(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = filepath.Join(tempDir, "output")
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+	config.Template = "default"
+	config.CopyOriginals = false
+	config.OutputFormat = "jpeg"
+	outputDir := filepath.Join(config.Output, "album")
+	err := os.MkdirAll(outputDir, 0755)
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(outputDir, "full_image1.jpg"), image.NewRGBA(image.Rect(0, 0, 300, 200)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	err = imgio.Save(filepath.Join(outputDir, "thumb_image1.jpg"), image.NewRGBA(image.Rect(0, 0, 30, 20)), imgio.JPEGEncoder(90))
+	assert.NoError(t, err)
+	info, err := os.Stat(filepath.Join(outputDir, "full_image1.jpg"))
+	assert.NoError(t, err)
+
+	item := newRSSItem(outputDir, "image1.jpg", Metadata{Title: `Fish & "chips"`}, time.Now())
+	assert.Equal(t, "https://example.com/album/full_image1.jpg", item.ImageURL)
+	assert.Equal(t, "image/jpeg", item.MIMEType)
+	assert.Equal(t, info.Size(), item.Length)
+	assert.Equal(t, []int{300, 200}, []int{item.Width, item.Height})
+	assert.Equal(t, "https://example.com/album/thumb_image1.jpg", item.ThumbURL)
+	assert.Equal(t, []int{30, 20}, []int{item.ThumbWidth, item.ThumbHeight})
+
+	// The default template renders the enclosures, escaping the item
+	tpl, err := parseTemplate("rss.go.xml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var buf bytes.Buffer
+	err = tpl.ExecuteTemplate(&buf, "rss.go.xml", RSSFeed{Items: []RSSItem{item}})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `<enclosure url="https://example.com/album/full_image1.jpg" length="`)
+	assert.Contains(t, buf.String(), `<media:content url="https://example.com/album/full_image1.jpg" type="image/jpeg" medium="image"`)
+	assert.Contains(t, buf.String(), `<media:thumbnail url="https://example.com/album/thumb_image1.jpg" width="30" height="20" />`)
+	assert.Contains(t, buf.String(), `<title>Fish &amp; &#34;chips&#34;</title>`)
+	assert.Contains(t, buf.String(), `<description>&lt;img src=&#34;https://example.com/album/thumb_image1.jpg&#34;`)
+}
+
+func TestFeedItem(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = filepath.Join(tempDir, "output")
+	config.Originals = filepath.Join(tempDir, "originals")
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+	pubDates = newPubDates()
+	t.Cleanup(func() {
+		pubDates = newPubDates()
+		config.FeedDate = "published"
+	})
+
+	first := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	taken := time.Date(2024, 8, 15, 9, 30, 0, 0, time.UTC)
+	original := filepath.Join(config.Originals, "album", "image1.jpg")
+	outputDir := filepath.Join(config.Output, "album")
+
+	// The item is dated and identified as when it was first seen
+	item := feedItem(original, "abc", outputDir, "image1.jpg", Metadata{DateTime: taken}, first)
+	assert.Equal(t, first, item.Date)
+	assert.Equal(t, first.Format(time.RFC1123Z), item.PubDate)
+	config.GalleryURL = "https://example.org"
+	item = feedItem(original, "abc", outputDir, "image1.jpg", Metadata{DateTime: taken}, time.Now())
+	assert.Equal(t, first, item.Date)
+	assert.Equal(t, "https://example.com/album/#image1.jpg", item.GUID)
+	assert.Equal(t, "https://example.org/album/#image1.jpg", item.Link)
+
+	// With feed_date set to taken, it's dated when it was taken, if known
+	config.FeedDate = "taken"
+	item = feedItem(original, "abc", outputDir, "image1.jpg", Metadata{DateTime: taken}, time.Now())
+	assert.Equal(t, taken, item.Date)
+	item = feedItem(original, "abc", outputDir, "image1.jpg", Metadata{}, time.Now())
+	assert.Equal(t, first, item.Date)
+}
+
+func TestRecentItems(t *testing.T) {
+	config.FeedMaxAge = 0
+	t.Cleanup(func() { config.FeedMaxAge = 0 })
+	items := []RSSItem{
+		{GUID: "new", Date: time.Now().Add(-time.Hour)},
+		{GUID: "old", Date: time.Now().AddDate(0, 0, -10)},
+	}
+
+	// Without a limit, all items are kept
+	assert.Len(t, recentItems(slices.Clone(items)), 2)
+
+	// With a limit, older items are left out
+	config.FeedMaxAge = 7
+	recent := recentItems(slices.Clone(items))
+	assert.Len(t, recent, 1)
+	assert.Equal(t, "new", recent[0].GUID)
+}
+
+func TestDigestItems(t *testing.T) {
+	config.FeedDigestTitle = "%d nye billeder"
+	t.Cleanup(func() { config.FeedDigestTitle = "%d new images" })
+	batch := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	earlier := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	feed := RSSFeed{
+		Link: "https://example.com/",
+		Items: []RSSItem{
+			{Title: "A", Link: "https://example.com/#a.jpg", GUID: "a", Date: batch, Description: `<img src="thumb_a.jpg" />`, ImageURL: "https://example.com/full_a.jpg"},
+			{Title: "B & C", Link: "https://example.com/#b.jpg", GUID: "b", Date: batch, Description: `<img src="thumb_b.jpg" />`},
+			{Title: "D", Link: "https://example.com/#d.jpg", GUID: "d", Date: earlier},
+		},
+	}
+
+	// Items published in the same build are grouped, the others left as they are
+	items := digestItems(feed)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "2 nye billeder", items[0].Title)
+	assert.Equal(t, "https://example.com/", items[0].Link)
+	assert.Equal(t, "https://example.com/#digest-20250602T120000Z", items[0].GUID)
+	assert.Equal(t, batch, items[0].Date)
+	assert.Equal(t, "https://example.com/full_a.jpg", items[0].ImageURL)
+	assert.Equal(t, `<p><a href="https://example.com/#a.jpg">A</a></p><img src="thumb_a.jpg" /><p><a href="https://example.com/#b.jpg">B &amp; C</a></p><img src="thumb_b.jpg" />`, items[0].Description)
+	assert.Equal(t, feed.Items[2], items[1])
+}
+
+func TestNewRSSFeed(t *testing.T) {
+	// Start from the default configuration
+	setupTestConfig(t)
+	config.Name = "Test Gallery"
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+
+	// The gallery name is used by default
+	feed := newRSSFeed(folderFeed{}, "")
+	assert.Equal(t, "Test Gallery", feed.Title)
+	assert.Equal(t, "Latest images from Test Gallery", feed.Description)
+	assert.Equal(t, "en-us", feed.Language)
+
+	// The title, description and language are configurable
+	config.FeedTitle = "Familiebilleder"
+	config.FeedDescription = "De nyeste billeder"
+	config.FeedLanguage = "da"
+	feed = newRSSFeed(folderFeed{}, "")
+	assert.Equal(t, "Familiebilleder", feed.Title)
+	assert.Equal(t, "De nyeste billeder", feed.Description)
+	assert.Equal(t, "da", feed.Language)
+
+	// Folder feeds are titled after the feed title and the folder
+	feed = newRSSFeed(folderFeed{Dir: "kids/2026", Title: "2026"}, "")
+	assert.Equal(t, "Familiebilleder - 2026", feed.Title)
+	assert.Equal(t, "https://example.com/kids/2026/", feed.Link)
+	assert.Equal(t, "da", feed.Language)
+	feed = newRSSFeed(folderFeed{Dir: "My Trips", Title: "My Trips"}, "")
+	assert.Equal(t, "https://example.com/My%20Trips/", feed.Link)
+}
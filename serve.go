@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// reloadPath is the path of the live reload event stream.
+const reloadPath = "/_gallery/reload"
+
+// reloadScript reloads the page when the server sends a reload event. It's
+// added to the pages served by the preview server, not to the generated files.
+const reloadScript = `<script>new EventSource("` + reloadPath + `").addEventListener("reload", () => location.reload());</script>`
+
+// runServe builds the gallery, and serves the output directory over HTTP until
+// interrupted. With watching enabled, changes to the originals, the config
+// file or the template trigger an incremental rebuild, after which open
+// browsers reload.
+func runServe(ctx context.Context, opts *options) error {
+	err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	// Serve the gallery even if some files failed to process, they're reported by process
+	err = process(ctx)
+	var failed *buildError
+	if err != nil && !errors.As(err, &failed) {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+
+	reload := newReloader()
+	handler := galleryHandler(reload)
+	slog.Info("Serving gallery", "url", "http://"+listener.Addr().String()+servePath())
+	if opts.Watch {
+		output, galleryPath := config.Output, config.GalleryPath
+		go watch(ctx, opts.Config, opts.PollInterval, func() {
+			rebuild(ctx, opts)
+			if config.Output != output || config.GalleryPath != galleryPath {
+				slog.Warn("The output directory or gallery path changed, restart the server to serve them")
+			}
+			reload.broadcast()
+		})
+	}
+	return serve(ctx, listener, handler)
+}
+
+// rebuild reloads the config file and rebuilds the gallery, logging failures
+// instead of returning them, so the server keeps running until they're fixed.
+func rebuild(ctx context.Context, opts *options) {
+	slog.Info("Rebuilding gallery")
+	err := loadConfig(opts)
+	if err != nil {
+		slog.Error("Failed to reload config, keeping the previous gallery", "error", err)
+		return
+	}
+	err = process(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("Failed to rebuild gallery", "error", err)
+		return
+	}
+	slog.Info("Gallery rebuilt")
+}
+
+// serve serves the handler on the listener until the context is cancelled.
+func serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	// Requests, including the live reload event streams, are cancelled with
+	// the context, so shutting down doesn't wait for them
+	server := &http.Server{Handler: handler, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		slog.Debug("Shutting down server")
+		server.Shutdown(context.Background())
+	}()
+
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// servePath returns the gallery path, with a leading and trailing slash.
+func servePath() string {
+	path := strings.Trim(config.GalleryPath, "/")
+	if path == "" {
+		return "/"
+	}
+	return "/" + path + "/"
+}
+
+// galleryHandler returns the handler serving the output directory under the
+// gallery path, with the live reload script added to its pages, and the live
+// reload event stream.
+func galleryHandler(reload *reloader) http.Handler {
+	prefix := servePath()
+	output := config.Output
+	files := http.FileServer(http.Dir(output))
+	mux := http.NewServeMux()
+	mux.Handle(prefix, http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !servePage(w, r, output) {
+			files.ServeHTTP(w, r)
+		}
+	})))
+	mux.Handle(reloadPath, reload)
+	if prefix != "/" {
+		mux.Handle("/{$}", http.RedirectHandler(prefix, http.StatusFound))
+	}
+	return mux
+}
+
+// servePage serves an HTML page from the output directory with the live
+// reload script added. It reports false if the request isn't for a page
+// that exists, leaving it to the file server.
+func servePage(w http.ResponseWriter, r *http.Request, output string) bool {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if path.Ext(name) != ".html" || strings.HasSuffix(r.URL.Path, "/index.html") {
+		// The file server redirects index.html to the directory
+		return false
+	}
+	page, err := os.ReadFile(filepath.Join(output, filepath.FromSlash(name)))
+	if err != nil {
+		return false
+	}
+
+	i := bytes.LastIndex(page, []byte("</body>"))
+	if i < 0 {
+		i = len(page)
+	}
+	page = append(page[:i:i], append([]byte(reloadScript), page[i:]...)...)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(page)
+	return true
+}
+
+// reloader pushes reload events to the open browsers over server-sent events.
+type reloader struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+// newReloader returns a reloader without clients.
+func newReloader() *reloader {
+	return &reloader{clients: map[chan struct{}]bool{}}
+}
+
+// ServeHTTP streams reload events to a browser until it disconnects.
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan struct{}, 1)
+	rl.mu.Lock()
+	rl.clients[client] = true
+	rl.mu.Unlock()
+	defer func() {
+		rl.mu.Lock()
+		delete(rl.clients, client)
+		rl.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	slog.Debug("Live reload client connected", "remote", r.RemoteAddr)
+
+	for {
+		select {
+		case <-client:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			slog.Debug("Live reload client disconnected", "remote", r.RemoteAddr)
+			return
+		}
+	}
+}
+
+// broadcast sends a reload event to every connected browser.
+func (rl *reloader) broadcast() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	slog.Debug("Reloading browsers", "clients", len(rl.clients))
+	for client := range rl.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+			// A reload is already pending for this client
+		}
+	}
+}
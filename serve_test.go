@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGalleryHandler(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = tempDir
+	config.GalleryPath = "/photos"
+	t.Cleanup(func() { config.GalleryPath = "/" })
+	err := os.WriteFile(filepath.Join(tempDir, "index.html"), []byte("<html><body>gallery</body></html>"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tempDir, "default.css"), []byte("body {}"), 0644)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(galleryHandler(newReloader()))
+	defer server.Close()
+
+	// Pages are served under the gallery path, with the live reload script added
+	resp, err := http.Get(server.URL + "/photos/")
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "<html><body>gallery"+reloadScript+"</body></html>", string(body))
+
+	// Other files are served as is
+	resp, err = http.Get(server.URL + "/photos/default.css")
+	assert.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "body {}", string(body))
+
+	// The root redirects to the gallery path
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = client.Get(server.URL + "/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/photos/", resp.Header.Get("Location"))
+}
+
+func TestReloader(t *testing.T) {
+	reload := newReloader()
+	server := httptest.NewServer(reload)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Wait for the client to be registered, then push a reload
+	assert.Eventually(t, func() bool {
+		reload.mu.Lock()
+		defer reload.mu.Unlock()
+		return len(reload.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+	reload.broadcast()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: reload\n", line)
+}
+
+func TestServe_Shutdown(t *testing.T) {
+	reload := newReloader()
+	listener := httptest.NewUnstartedServer(nil).Listener
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, listener, reload) }()
+
+	// An open live reload stream doesn't keep the server from shutting down
+	resp, err := http.Get("http://" + listener.Addr().String())
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	cancel()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't shut down")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Originals = filepath.Join(tempDir, "originals")
+	// An output directory inside the originals directory isn't watched, as every build changes it
+	config.Output = filepath.Join(config.Originals, "output")
+	config.Template = "default"
+	t.Cleanup(func() { config.Output = "output" })
+	configFile := filepath.Join(tempDir, "config.yml")
+	err := os.MkdirAll(config.Output, 0755)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{config.Originals, configFile, filepath.Join("templates", "default")}, watchedPaths(configFile))
+
+	rebuilds := atomic.Int32{}
+	ctx, cancel := context.WithCancel(context.Background())
+	watched := make(chan struct{})
+	go func() {
+		watch(ctx, configFile, 10*time.Millisecond, func() { rebuilds.Add(1) })
+		close(watched)
+	}()
+	// Stop the watcher before the config is restored
+	defer func() {
+		cancel()
+		<-watched
+	}()
+
+	// Changes in the output directory don't trigger a rebuild
+	time.Sleep(20 * time.Millisecond)
+	err = os.WriteFile(filepath.Join(config.Output, "index.html"), []byte("page"), 0644)
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), rebuilds.Load())
+
+	// A new original triggers a rebuild, once the change has settled
+	err = os.WriteFile(filepath.Join(config.Originals, "image1.jpg"), []byte("jpeg"), 0644)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return rebuilds.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// So does creating the config file
+	err = os.WriteFile(configFile, []byte("name: Watched\n"), 0644)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return rebuilds.Load() == 2 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sitemapFile is the name of the sitemap in the output directory, or of the
+// sitemap index if the URLs are split over several sitemaps.
+const sitemapFile = "sitemap.xml"
+
+// robotsFile is the name of the generated robots.txt in the output directory.
+const robotsFile = "robots.txt"
+
+// sitemapMaxURLs is the number of URLs a sitemap may list. Beyond that, the
+// URLs are split over several sitemaps, listed in a sitemap index.
+var sitemapMaxURLs = 50000
+
+// sitemapMaxImages is the number of images a URL of a sitemap may list.
+const sitemapMaxImages = 1000
+
+// Namespaces of the sitemap protocol, and of the Google image sitemap extension.
+const (
+	sitemapNamespace      = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	sitemapImageNamespace = "http://www.google.com/schemas/sitemap-image/1.1"
+)
+
+// sitemapURLSet is a sitemap, see https://www.sitemaps.org/protocol.html.
+type sitemapURLSet struct {
+	XMLName    xml.Name     `xml:"urlset"`
+	Namespace  string       `xml:"xmlns,attr"`
+	ImageSpace string       `xml:"xmlns:image,attr"`
+	URLs       []sitemapURL `xml:"url"`
+}
+
+// sitemapURL is a page listed in a sitemap, with the images on it.
+type sitemapURL struct {
+	Loc    string         `xml:"loc"`
+	Images []sitemapImage `xml:"image:image"`
+}
+
+// sitemapImage is an image listed in a sitemap, with its caption if it has one.
+type sitemapImage struct {
+	Loc     string `xml:"image:loc"`
+	Caption string `xml:"image:caption,omitempty"`
+}
+
+// sitemapIndex is a sitemap index, listing the sitemaps the URLs are split over.
+type sitemapIndex struct {
+	XMLName   xml.Name          `xml:"sitemapindex"`
+	Namespace string            `xml:"xmlns,attr"`
+	Sitemaps  []sitemapLocation `xml:"sitemap"`
+}
+
+// sitemapLocation is a sitemap listed in a sitemap index.
+type sitemapLocation struct {
+	Loc string `xml:"loc"`
+}
+
+// sitemapURLs returns the URLs of the pages of the gallery, sorted by
+// directory: the index page of every directory, with its images, or followed
+// by the image pages if enabled. Hidden directories are left out.
+func (dm DirMap) sitemapURLs() []sitemapURL {
+	urls := []sitemapURL{}
+	for _, path := range slices.Sorted(maps.Keys(dm)) {
+		dir := dm[path]
+		if dir.Folder.Hidden || (len(dir.Files) == 0 && len(dir.SubDirs) == 0) {
+			continue
+		}
+		rel := manifestKey(path)
+		pageURL := folderURL(rel)
+		files := slices.SortedFunc(maps.Values(dir.Files), func(a, b File) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		if config.ImagePages {
+			urls = append(urls, sitemapURL{Loc: pageURL})
+			for _, file := range files {
+				urls = append(urls, sitemapURL{
+					Loc:    absoluteURL(rel, imagePageName(file.Name)),
+					Images: []sitemapImage{newSitemapImage(rel, file)},
+				})
+			}
+			continue
+		}
+
+		images := []sitemapImage{}
+		for _, file := range files {
+			if len(images) == sitemapMaxImages {
+				slog.Debug("Leaving out the remaining images of a directory from the sitemap", "dir", path)
+				break
+			}
+			images = append(images, newSitemapImage(rel, file))
+		}
+		urls = append(urls, sitemapURL{Loc: pageURL, Images: images})
+	}
+	return urls
+}
+
+// newSitemapImage returns the sitemap entry of the full size image of a
+// file, captioned with its caption, or its title if it has none.
+func newSitemapImage(dir string, file File) sitemapImage {
+	caption := file.Metadata.Caption
+	if caption == "" {
+		caption = file.Metadata.Title
+	}
+	return sitemapImage{
+		Loc:     absoluteURL(dir, derivedName("full", file.Name)),
+		Caption: caption,
+	}
+}
+
+// sitemapFiles returns the files the given number of sitemap URLs are written
+// to: the sitemap, or the sitemap index followed by the sitemaps.
+func sitemapFiles(urls int) []string {
+	files := []string{sitemapFile}
+	if urls <= sitemapMaxURLs {
+		return files
+	}
+	for i := range (urls + sitemapMaxURLs - 1) / sitemapMaxURLs {
+		files = append(files, fmt.Sprintf("sitemap-%d.xml", i+1))
+	}
+	return files
+}
+
+// writeSitemap writes the sitemap, split over several sitemaps listed in a
+// sitemap index if there are too many URLs, unless the URLs haven't changed
+// since it was written, and records it in the manifest.
+func writeSitemap(urls []sitemapURL) error {
+	files := sitemapFiles(len(urls))
+	hash := fingerprint(urls, files)
+	entry, ok := manifest.sitemap(sitemapFile)
+	if ok && entry.Hash == hash && filesExist(entry.Files) {
+		slog.Debug("Sitemap is up to date, skipping write")
+		return nil
+	}
+
+	slog.Debug("Updating sitemap", "urls", len(urls), "files", len(files))
+	if len(files) == 1 {
+		err := writeXMLFile(sitemapFile, sitemapURLSet{Namespace: sitemapNamespace, ImageSpace: sitemapImageNamespace, URLs: urls})
+		if err != nil {
+			return err
+		}
+	} else {
+		index := sitemapIndex{Namespace: sitemapNamespace}
+		for i, file := range files[1:] {
+			end := min((i+1)*sitemapMaxURLs, len(urls))
+			err := writeXMLFile(file, sitemapURLSet{Namespace: sitemapNamespace, ImageSpace: sitemapImageNamespace, URLs: urls[i*sitemapMaxURLs : end]})
+			if err != nil {
+				return err
+			}
+			index.Sitemaps = append(index.Sitemaps, sitemapLocation{Loc: absoluteURL("", file)})
+		}
+		err := writeXMLFile(sitemapFile, index)
+		if err != nil {
+			return err
+		}
+	}
+	manifest.setSitemap(sitemapFile, ManifestPage{Hash: hash, Files: files})
+	return nil
+}
+
+// writeXMLFile writes a value as an XML document to a file in the output directory.
+func writeXMLFile(file string, v any) error {
+	path := filepath.Join(config.Output, file)
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(v); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	slog.Debug("XML file written", "path", path)
+	return nil
+}
+
+// writeRobots writes a robots.txt allowing everything and pointing at the
+// sitemap, unless it hasn't changed since it was written, and records it in
+// the manifest. A robots.txt the manifest doesn't list was added by the user,
+// so it's left alone, unless it's identical to the one we'd write.
+func writeRobots() error {
+	content := "User-agent: *\nAllow: /\n\nSitemap: " + absoluteURL("", sitemapFile) + "\n"
+	hash := hashData([]byte(content))
+	robotsPath := filepath.Join(config.Output, robotsFile)
+	entry, ok := manifest.sitemap(robotsFile)
+	if ok && entry.Hash == hash && filesExist(entry.Files) {
+		slog.Debug("robots.txt is up to date, skipping write")
+		return nil
+	}
+	if !ok {
+		existing, err := os.ReadFile(robotsPath)
+		if err == nil && string(existing) != content {
+			slog.Warn("Leaving the existing robots.txt alone, remove it to have it generated", "path", robotsPath)
+			return nil
+		}
+	}
+
+	err := writeFileAtomic(robotsPath, func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", robotsFile, err)
+	}
+	manifest.setSitemap(robotsFile, ManifestPage{Hash: hash, Files: []string{robotsFile}})
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSitemapURLs(t *testing.T) {
+	config.Originals = "originals"
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/gallery/"
+	t.Cleanup(func() {
+		config.GalleryPath = "/"
+		config.ImagePages = false
+	})
+
+	dirs := DirMap{}
+	dirs.AddDir("originals", "originals", false)
+	dirs.AddDir("originals/trip", "trip", false)
+	dirs.AddDir("originals/private", "private", false)
+	dirs["originals"].SubDirs["originals/trip"] = SubDir{Name: "trip"}
+	dirs["originals/trip"].Files["originals/trip/b.jpg"] = File{Name: "b.jpg", Metadata: Metadata{Title: "Beach"}}
+	dirs["originals/trip"].Files["originals/trip/a.jpg"] = File{Name: "a.jpg", Metadata: Metadata{Title: "Alps", Caption: "The Alps & more"}}
+	dirs["originals/private"].Files["originals/private/c.jpg"] = File{Name: "c.jpg"}
+	dirs.AddDir("originals/My Album", "My Album", false)
+	dirs["originals"].SubDirs["originals/My Album"] = SubDir{Name: "My Album"}
+	dirs["originals/My Album"].Files["originals/My Album/IMG #1.jpg"] = File{Name: "IMG #1.jpg"}
+	private := dirs["originals/private"]
+	private.Folder.Hidden = true
+	dirs["originals/private"] = private
+
+	// The index pages list their images, hidden directories are left out, and
+	// the URLs are percent-encoded
+	assert.Equal(t, []sitemapURL{
+		{Loc: "https://example.com/gallery/", Images: []sitemapImage{}},
+		{Loc: "https://example.com/gallery/My%20Album/", Images: []sitemapImage{
+			{Loc: "https://example.com/gallery/My%20Album/full_IMG%20%231.jpg"},
+		}},
+		{Loc: "https://example.com/gallery/trip/", Images: []sitemapImage{
+			{Loc: "https://example.com/gallery/trip/full_a.jpg", Caption: "The Alps & more"},
+			{Loc: "https://example.com/gallery/trip/full_b.jpg", Caption: "Beach"},
+		}},
+	}, dirs.sitemapURLs())
+
+	// With image pages, the images are listed on their pages instead
+	config.ImagePages = true
+	assert.Equal(t, []sitemapURL{
+		{Loc: "https://example.com/gallery/"},
+		{Loc: "https://example.com/gallery/My%20Album/"},
+		{Loc: "https://example.com/gallery/My%20Album/IMG%20%231.jpg.html", Images: []sitemapImage{{Loc: "https://example.com/gallery/My%20Album/full_IMG%20%231.jpg"}}},
+		{Loc: "https://example.com/gallery/trip/"},
+		{Loc: "https://example.com/gallery/trip/a.jpg.html", Images: []sitemapImage{{Loc: "https://example.com/gallery/trip/full_a.jpg", Caption: "The Alps & more"}}},
+		{Loc: "https://example.com/gallery/trip/b.jpg.html", Images: []sitemapImage{{Loc: "https://example.com/gallery/trip/full_b.jpg", Caption: "Beach"}}},
+	}, dirs.sitemapURLs())
+}
+
+func TestWriteSitemap(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = tempDir
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+	manifest = newManifest()
+	sitemapMaxURLs = 2
+	t.Cleanup(func() { sitemapMaxURLs = 50000 })
+
+	// A sitemap within the limit is written as is, escaping the captions
+	urls := []sitemapURL{
+		{Loc: "https://example.com/", Images: []sitemapImage{{Loc: "https://example.com/full_a.jpg", Caption: "Fish & chips"}}},
+		{Loc: "https://example.com/trip/"},
+	}
+	assert.Equal(t, []string{"sitemap.xml"}, sitemapFiles(len(urls)))
+	err := writeSitemap(urls)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(tempDir, "sitemap.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">`)
+	assert.Contains(t, string(content), "<image:loc>https://example.com/full_a.jpg</image:loc>")
+	assert.Contains(t, string(content), "<image:caption>Fish &amp; chips</image:caption>")
+
+	// Beyond the limit, the URLs are split over sitemaps listed in an index
+	urls = append(urls, sitemapURL{Loc: "https://example.com/other/"})
+	assert.Equal(t, []string{"sitemap.xml", "sitemap-1.xml", "sitemap-2.xml"}, sitemapFiles(len(urls)))
+	err = writeSitemap(urls)
+	assert.NoError(t, err)
+	content, err = os.ReadFile(filepath.Join(tempDir, "sitemap.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "<sitemapindex")
+	assert.Contains(t, string(content), "<loc>https://example.com/sitemap-2.xml</loc>")
+	content, err = os.ReadFile(filepath.Join(tempDir, "sitemap-2.xml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "<loc>https://example.com/other/</loc>")
+	assert.NotContains(t, string(content), "<loc>https://example.com/trip/</loc>")
+}
+
+func TestWriteRobots(t *testing.T) {
+	tempDir := t.TempDir()
+	config.Output = tempDir
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+	manifest = newManifest()
+	robotsPath := filepath.Join(tempDir, "robots.txt")
+
+	// A robots.txt added by the user is left alone, and isn't recorded
+	err := os.WriteFile(robotsPath, []byte("User-agent: *\nDisallow: /private/\n"), 0644)
+	assert.NoError(t, err)
+	err = writeRobots()
+	assert.NoError(t, err)
+	content, err := os.ReadFile(robotsPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "User-agent: *\nDisallow: /private/\n", string(content))
+	_, ok := manifest.sitemap("robots.txt")
+	assert.False(t, ok)
+
+	// Without one, it's written and recorded, and updated from then on
+	err = os.Remove(robotsPath)
+	assert.NoError(t, err)
+	err = writeRobots()
+	assert.NoError(t, err)
+	_, ok = manifest.sitemap("robots.txt")
+	assert.True(t, ok)
+	config.GalleryURL = "https://example.org"
+	err = writeRobots()
+	assert.NoError(t, err)
+	content, err = os.ReadFile(robotsPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "Sitemap: https://example.org/sitemap.xml\n")
+
+	// One identical to the generated one is taken over, like after a full rebuild
+	manifest = newManifest()
+	err = writeRobots()
+	assert.NoError(t, err)
+	_, ok = manifest.sitemap("robots.txt")
+	assert.True(t, ok)
+}
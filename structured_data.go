@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// schemaContext is the JSON-LD context of the schema.org vocabulary.
+const schemaContext = "https://schema.org"
+
+// schemaImageObject is a schema.org ImageObject, see https://schema.org/ImageObject.
+type schemaImageObject struct {
+	Context         string `json:"@context,omitempty"`
+	Type            string `json:"@type"`
+	Name            string `json:"name,omitempty"`
+	Caption         string `json:"caption,omitempty"`
+	ContentURL      string `json:"contentUrl"`
+	ThumbnailURL    string `json:"thumbnailUrl,omitempty"`
+	URL             string `json:"url,omitempty"`
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+	DateCreated     string `json:"dateCreated,omitempty"`
+	CopyrightNotice string `json:"copyrightNotice,omitempty"`
+}
+
+// schemaImageGallery is a schema.org ImageGallery, see https://schema.org/ImageGallery.
+type schemaImageGallery struct {
+	Context         string              `json:"@context"`
+	Type            string              `json:"@type"`
+	Name            string              `json:"name"`
+	Description     string              `json:"description,omitempty"`
+	URL             string              `json:"url"`
+	Image           *schemaImageObject  `json:"image,omitempty"`
+	AssociatedMedia []schemaImageObject `json:"associatedMedia,omitempty"`
+}
+
+// newSchemaImageObject returns the ImageObject of an image, with absolute URLs.
+func newSchemaImageObject(image Image) schemaImageObject {
+	object := schemaImageObject{
+		Type:            "ImageObject",
+		Name:            image.Metadata.title(image.File),
+		Caption:         image.Metadata.Caption,
+		ContentURL:      absoluteURL(image.Path, image.Full),
+		ThumbnailURL:    absoluteURL(image.Path, image.Thumb),
+		CopyrightNotice: config.Copyright,
+	}
+	if image.Page != "" {
+		object.URL = absoluteURL(image.Path, image.Page)
+	}
+	if !image.Metadata.DateTime.IsZero() {
+		object.DateCreated = image.Metadata.DateTime.Format(time.RFC3339)
+	}
+	return object
+}
+
+// jsonLD returns a value encoded as JSON-LD. encoding/json escapes <, > and
+// &, so the result can be embedded in a script element as is.
+func jsonLD(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Only values that can't be encoded end up here, which is a programming error
+		panic(err)
+	}
+	return string(data)
+}
+
+// htmlTags matches the tags of an HTML fragment.
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+
+// plainText returns the text of an HTML fragment, like a rendered
+// description, on a single line, for meta tags and JSON-LD.
+func plainText(fragment string) string {
+	text := html.UnescapeString(htmlTags.ReplaceAllString(fragment, " "))
+	return strings.Join(strings.Fields(text), " ")
+}
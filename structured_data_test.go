@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlainText(t *testing.T) {
+	assert.Equal(t, "Photos from Rome & Lazio. Second paragraph.", plainText("<p>Photos from <strong>Rome</strong> &amp; Lazio.</p>\n<p>Second\nparagraph.</p>\n"))
+	assert.Equal(t, "", plainText(""))
+}
+
+func TestJSONLD(t *testing.T) {
+	config.GalleryURL = "https://example.com"
+	config.GalleryPath = "/"
+	config.Copyright = "Jane Doe"
+	t.Cleanup(func() { config.Copyright = "" })
+
+	taken := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	object := newSchemaImageObject(Image{
+		File:     "image1.jpg",
+		Thumb:    "thumb_image1.jpg",
+		Full:     "full_image1.jpg",
+		Path:     "trip",
+		Metadata: Metadata{Title: "</script><script>alert(1)</script>", DateTime: taken},
+	})
+	assert.Equal(t, "https://example.com/trip/full_image1.jpg", object.ContentURL)
+	assert.Equal(t, "https://example.com/trip/thumb_image1.jpg", object.ThumbnailURL)
+	assert.Empty(t, object.URL)
+	assert.Equal(t, "2025-06-01T12:00:00Z", object.DateCreated)
+	assert.Equal(t, "Jane Doe", object.CopyrightNotice)
+
+	// The JSON-LD can't close the script element it's embedded in
+	data := jsonLD(object)
+	assert.Contains(t, data, `"@type":"ImageObject"`)
+	assert.NotContains(t, data, "</script>")
+	assert.Contains(t, data, `\u003c/script\u003e`)
+}
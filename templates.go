@@ -0,0 +1,123 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+// embeddedTemplates holds the templates shipped with the binary.
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// templatesDir is the directory holding the templates, both embedded and in
+// the working directory.
+const templatesDir = "templates"
+
+// templateFS returns the files of the configured template. A template given
+// as a path (e.g. "./mytheme") is read from that directory. A template given
+// by name is read from the templates directory in the working directory if
+// it's there, so an exported theme can be customized, and from the templates
+// embedded in the binary otherwise.
+func templateFS() (fs.FS, error) {
+	name := config.Template
+	if dir := templateDir(); dir != "" {
+		slog.Debug("Using template directory", "template", name, "dir", dir)
+		return os.DirFS(dir), nil
+	}
+
+	embedded, err := fs.Sub(embeddedTemplates, templatesDir+"/"+name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.Stat(embedded, "."); err != nil {
+		return nil, fmt.Errorf("template %s not found, must be one of %v or a directory", name, embeddedTemplateNames())
+	}
+	slog.Debug("Using embedded template", "template", name)
+	return embedded, nil
+}
+
+// templateDir returns the directory of the configured template, or an empty
+// string if the embedded template is used.
+func templateDir() string {
+	name := config.Template
+	if filepath.Base(name) != name {
+		return name
+	}
+	dir := filepath.Join(templatesDir, name)
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return ""
+}
+
+// embeddedTemplateNames returns the names of the embedded templates.
+func embeddedTemplateNames() []string {
+	names := []string{}
+	entries, _ := embeddedTemplates.ReadDir(templatesDir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readTemplateFile returns the content of a file in the configured template.
+func readTemplateFile(file string) ([]byte, error) {
+	fsys, err := templateFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadFile(fsys, file)
+}
+
+// parseTemplate parses a file in the configured template.
+func parseTemplate(file string) (*template.Template, error) {
+	fsys, err := templateFS()
+	if err != nil {
+		return nil, err
+	}
+	return template.ParseFS(fsys, file)
+}
+
+// templateFileHash returns the content hash of a file in the configured
+// template, or an empty string if it can't be read.
+func templateFileHash(file string) string {
+	data, err := readTemplateFile(file)
+	if err != nil {
+		slog.Debug("Failed to hash template file", "file", file, "error", err)
+		return ""
+	}
+	return hashData(data)
+}
+
+// exportTemplate writes an embedded template to the templates directory in
+// the working directory, where it's used instead of the embedded one.
+// An existing directory is never overwritten.
+func exportTemplate(name string) (string, error) {
+	embedded, err := fs.Sub(embeddedTemplates, templatesDir+"/"+name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fs.Stat(embedded, "."); err != nil {
+		return "", fmt.Errorf("template %s not found, must be one of %v", name, embeddedTemplateNames())
+	}
+
+	dir := filepath.Join(templatesDir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return "", fmt.Errorf("template directory %s already exists", dir)
+	}
+	err = os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return "", err
+	}
+	return dir, os.CopyFS(dir, embedded)
+}
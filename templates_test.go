@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFS(t *testing.T) {
+	t.Cleanup(func() { config.Template = "default" })
+	embeddedCSS, err := embeddedTemplates.ReadFile("templates/default/default.css")
+	assert.NoError(t, err)
+
+	// Outside a checkout, the embedded templates are used
+	t.Chdir(t.TempDir())
+	config.Template = "default"
+	data, err := readTemplateFile("default.css")
+	assert.NoError(t, err)
+	assert.Equal(t, embeddedCSS, data)
+	_, err = parseTemplate("index.go.html")
+	assert.NoError(t, err)
+
+	// A template in the working directory takes precedence over the embedded one
+	err = os.MkdirAll(filepath.Join("templates", "default"), 0755)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join("templates", "default", "default.css"), []byte("custom"), 0644)
+	assert.NoError(t, err)
+	data, err = readTemplateFile("default.css")
+	assert.NoError(t, err)
+	assert.Equal(t, "custom", string(data))
+
+	// A template given as a path is read from that directory
+	err = os.MkdirAll(filepath.Join("themes", "mine"), 0755)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join("themes", "mine", "default.css"), []byte("mine"), 0644)
+	assert.NoError(t, err)
+	config.Template = filepath.Join("themes", "mine")
+	data, err = readTemplateFile("default.css")
+	assert.NoError(t, err)
+	assert.Equal(t, "mine", string(data))
+
+	// An unknown template is an error
+	config.Template = "missing"
+	_, err = readTemplateFile("default.css")
+	assert.ErrorContains(t, err, "template missing not found, must be one of [default default-imgid]")
+	assert.Equal(t, "", templateFileHash("default.css"))
+}
+
+func TestEmbeddedTemplates(t *testing.T) {
+	t.Cleanup(func() { config.Template = "default" })
+	t.Chdir(t.TempDir())
+
+	// Every embedded template has the page and feed templates, as any of them can be enabled
+	files := []string{"index.go.html", "image.go.html"}
+	for _, format := range feedFormats {
+		if format.Template != "" {
+			files = append(files, format.Template)
+		}
+	}
+	for _, name := range embeddedTemplateNames() {
+		config.Template = name
+		for _, file := range files {
+			_, err := parseTemplate(file)
+			assert.NoError(t, err, name+"/"+file)
+		}
+	}
+}
+
+func TestExportTemplate(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	dir, err := exportTemplate("default-imgid")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("templates", "default-imgid"), dir)
+	for _, file := range []string{"index.go.html", "image.go.html", "rss.go.xml", "atom.go.xml", "default.css", "default.js", "folder.svg"} {
+		exported, err := os.ReadFile(filepath.Join(dir, file))
+		assert.NoError(t, err, file)
+		embedded, err := embeddedTemplates.ReadFile("templates/default-imgid/" + file)
+		assert.NoError(t, err, file)
+		assert.Equal(t, embedded, exported, file)
+	}
+
+	// An existing template directory is never overwritten
+	_, err = exportTemplate("default-imgid")
+	assert.ErrorContains(t, err, "already exists")
+	_, err = exportTemplate("missing")
+	assert.ErrorContains(t, err, "template missing not found")
+}
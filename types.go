@@ -5,9 +5,22 @@ import (
 )
 
 // Metadata represents the metadata of an image file, with EXIF and IPTC metadata as the value.
+// EXIF and IPTC hold label/value pairs ready for display, while the remaining
+// fields hold the individual values for templates that want to lay them out themselves.
 type Metadata struct {
-	EXIF [][]string
-	IPTC [][]string
+	EXIF         [][]string
+	IPTC         [][]string
+	Camera       string
+	Lens         string
+	FocalLength  string
+	Aperture     string
+	ShutterSpeed string
+	ISO          string
+	DateTime     time.Time
+	Orientation  int
+	Title        string
+	Caption      string
+	Keywords     []string
 }
 
 // Folders represents a list of folders, with the folder names as the value.
@@ -15,14 +28,31 @@ type Folders struct {
 	Folders []string
 }
 
+// RSSItem represents an image in the feeds. PubDate is Date formatted for RSS,
+// and Description is HTML. ImageURL, MIMEType, Length (in bytes), Width and
+// Height describe the full size image, and ThumbURL, ThumbWidth and
+// ThumbHeight the thumbnail, for the enclosures. They're empty if the
+// derived images couldn't be read. Feeds lists the feeds the item is part of.
 type RSSItem struct {
 	Title       string
 	Description string
 	Link        string
 	PubDate     string
+	Date        time.Time
 	GUID        string
+	ImageURL    string
+	MIMEType    string
+	Length      int64
+	Width       int
+	Height      int
+	ThumbURL    string
+	ThumbWidth  int
+	ThumbHeight int
+	Feeds       []folderFeed `json:"-"`
 }
 
+// RSSFeed represents a feed, in any of the feed formats. AtomLink is the URL
+// of the feed itself, and Updated the date of its newest item in RFC 3339 format.
 type RSSFeed struct {
 	Title         string
 	Description   string
@@ -31,18 +61,49 @@ type RSSFeed struct {
 	AtomLink      string
 	Language      string
 	LastBuildDate string
+	Updated       string
 	Items         []RSSItem
 }
 
 // Image represents an image file, with a description, a file name, a path, and metadata.
+// Thumb and Full are the file names of the derived thumbnail and full size images,
+// Sources the responsive sizes, and Variants the same images in alternative formats.
+// Srcset lists the responsive sizes, and FullSrcset the full size image as well.
+// Page is the file name of the image page, empty unless image pages are enabled.
 type Image struct {
 	Description string
 	File        string
+	Thumb       string
+	Full        string
+	Page        string
+	Variants    []Variant
+	Sources     []Source
+	Srcset      string
+	FullSrcset  string
 	Path        string
 	Metadata    Metadata
 	Index       int
 }
 
+// Variant represents the derived images of an image in an alternative format,
+// with the MIME type and the file names of the thumbnail and full size image.
+type Variant struct {
+	Type       string
+	Thumb      string
+	Full       string
+	Sources    []Source
+	Srcset     string
+	FullSrcset string
+}
+
+// Source represents a responsive size of an image, with the size name, its
+// width in pixels, and the file name. Srcset joins them for use in srcset attributes.
+type Source struct {
+	Name  string
+	Width int
+	File  string
+}
+
 // Directory represents a directory with a path and name.
 // FIXME: Rename to "Path"?
 type NavigationElement struct {
@@ -51,25 +112,100 @@ type NavigationElement struct {
 }
 
 // Gallery represents a gallery, with metadata and content.
+// Title and Description (rendered from markdown) come from the folder config
+// file, and are empty if it doesn't set them, Summary being the description
+// as plain text. Cover is the file name of the cover thumbnail of the
+// directory, empty if it has no images. URL is the absolute URL of the page,
+// and ImageURL, ImageWidth and ImageHeight describe the full size image of
+// the cover, for OpenGraph and Twitter card tags. JSONLD is the schema.org
+// ImageGallery of the page. They're empty without a gallery URL.
 type Gallery struct {
 	Name        string
+	Title       string
+	Description string
+	Summary     string
+	Cover       string
+	URL         string
+	ImageURL    string
+	ImageWidth  int
+	ImageHeight int
+	JSONLD      string
 	Copyright   string
-	Folders     []string
+	Folders     []Folder
 	Navigation  []NavigationElement
 	Images      []Image
+	Feeds       []FeedLink
+	Year        int
+	GalleryPath string
+}
+
+// Folder represents a subfolder listed on an index page, with its name, its
+// title (its name unless the folder config sets one), and the link to its
+// index page. Images and Latest count the images of its subfolders too, and
+// Cover is the path of its cover thumbnail, empty if it has no images. Link
+// and Cover are percent-encoded URLs.
+type Folder struct {
+	Name   string
+	Title  string
+	Link   string
+	Images int
+	Latest time.Time
+	Cover  string
+}
+
+// ImagePage represents the page of a single image, with the file names of
+// the pages of the previous and next image in the folder (empty at either
+// end), and the link to the folder index. URL and ImageURL are the absolute
+// URLs of the page and the full size image, for OpenGraph and Twitter card
+// tags, with the dimensions of the image, and JSONLD is the schema.org
+// ImageObject of the image. They're empty without a gallery URL.
+type ImagePage struct {
+	Name        string
+	Title       string
+	Folder      string
+	Copyright   string
+	Image       Image
+	Navigation  []NavigationElement
+	Prev        string
+	Next        string
+	Up          string
+	URL         string
+	ImageURL    string
+	ImageWidth  int
+	ImageHeight int
+	JSONLD      string
+	Feeds       []FeedLink
 	Year        int
 	GalleryPath string
 }
 
-// File represents a file on disk, with a name and a modification time.
+// File represents a file on disk, with a name, a modification time, a content hash, and its metadata.
 type File struct {
-	Name    string
-	ModTime time.Time
+	Name     string
+	ModTime  time.Time
+	Hash     string
+	Metadata Metadata
+}
+
+// date returns the date an image was taken, or its modification time if the metadata doesn't tell.
+func (f File) date() time.Time {
+	if !f.Metadata.DateTime.IsZero() {
+		return f.Metadata.DateTime
+	}
+	return f.ModTime
 }
 
-// SubDir represents a subdirectory on disk, with a name.
+// SubDir represents a subdirectory on disk, with a name, the title, sort
+// position and hidden flag from its folder config, and its image count,
+// latest image date and cover thumbnail file name, once the walk is done.
 type SubDir struct {
-	Name string
+	Name   string
+	Title  string
+	Sort   int
+	Hidden bool
+	Images int
+	Latest time.Time
+	Cover  string
 }
 
 // Dir represents the content of a directory on disk.
@@ -79,6 +215,28 @@ type Dir struct {
 	Files       map[string]File
 	SubDirs     map[string]SubDir
 	NeedsUpdate bool
+	Hash        string
+	Folder      FolderConfig
+	Cover       string
+	CoverSource string
+}
+
+// imageTask is an original image to generate derived images for, with the hash
+// of its content, the thumbnail size of its folder, and the feeds it's part of.
+type imageTask struct {
+	Path      string
+	Hash      string
+	ThumbSize int
+	Feeds     []folderFeed
+}
+
+// coverTask is a directory to generate the cover thumbnail for, from the
+// original image with the given hash, in the thumbnail size of the directory.
+type coverTask struct {
+	Dir       string
+	Source    string
+	Hash      string
+	ThumbSize int
 }
 
 // DirMap is a map of directories on disk, with the path as the key.
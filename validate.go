@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"text/template"
+)
+
+// runValidate checks the config file, and that the originals directory and
+// the template files it refers to exist, without building anything.
+func runValidate(ctx context.Context, opts *options) error {
+	err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	err = validateSetup()
+	if err != nil {
+		return err
+	}
+	slog.Info("Configuration is valid", "config", opts.Config)
+	return nil
+}
+
+// validateSetup checks that the originals directory exists, and that the
+// template files parse, returning every problem found.
+func validateSetup() error {
+	problems := []error{}
+
+	info, err := os.Stat(config.Originals)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("originals directory: %w", err))
+	} else if !info.IsDir() {
+		problems = append(problems, fmt.Errorf("originals directory: %s is not a directory", config.Originals))
+	}
+
+	fsys, err := templateFS()
+	if err != nil {
+		return errors.Join(append(problems, err)...)
+	}
+	templates := []string{"index.go.html"}
+	if config.ImagePages {
+		templates = append(templates, "image.go.html")
+	}
+	for _, format := range enabledFeeds() {
+		if format.Template != "" {
+			templates = append(templates, format.Template)
+		}
+	}
+	for _, file := range templates {
+		if _, err := template.ParseFS(fsys, file); err != nil {
+			problems = append(problems, fmt.Errorf("template %s: %w", config.Template, err))
+		}
+	}
+	for _, file := range []string{"default.css", "default.js", "folder.svg"} {
+		if _, err := fs.Stat(fsys, file); err != nil {
+			problems = append(problems, fmt.Errorf("template %s: %w", config.Template, err))
+		}
+	}
+
+	return errors.Join(problems...)
+}
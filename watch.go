@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileState is the state of a watched file, used to detect changes.
+type fileState struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// snapshot returns the state of every file below the given paths. Missing
+// paths are left out, so creating them is detected as a change too.
+// Files in the output directory are skipped, as they change on every build.
+func snapshot(paths []string) map[string]fileState {
+	files := map[string]fileState{}
+	output := filepath.Clean(config.Output)
+	for _, root := range paths {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// The path may be deleted while walking, which the next snapshot picks up
+				return nil
+			}
+			if path == output || strings.HasPrefix(path, output+string(filepath.Separator)) {
+				return filepath.SkipDir
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files[path] = fileState{ModTime: info.ModTime(), Size: info.Size()}
+			return nil
+		})
+	}
+	return files
+}
+
+// watchedPaths returns the paths a change of which triggers a rebuild: the
+// originals directory, the config file, and the template directory unless
+// the template is embedded.
+func watchedPaths(configFile string) []string {
+	paths := []string{config.Originals, configFile}
+	if dir := templateDir(); dir != "" {
+		paths = append(paths, dir)
+	}
+	return paths
+}
+
+// watch polls the watched paths every interval, and calls rebuild once a
+// change has settled, i.e. nothing changed during the following interval,
+// so a batch of copied photos triggers a single rebuild. It returns when the
+// context is cancelled.
+func watch(ctx context.Context, configFile string, interval time.Duration, rebuild func()) {
+	paths := watchedPaths(configFile)
+	previous := snapshot(paths)
+	changed := false
+	slog.Info("Watching for changes", "paths", paths, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Debug("Stopping watcher")
+			return
+		case <-ticker.C:
+		}
+
+		current := snapshot(paths)
+		if !maps.Equal(previous, current) {
+			slog.Debug("Change detected, waiting for it to settle")
+			previous = current
+			changed = true
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		changed = false
+		rebuild()
+		// The rebuild may have changed the watched paths, e.g. the originals directory in the config file
+		paths = watchedPaths(configFile)
+		previous = snapshot(paths)
+	}
+}